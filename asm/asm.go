@@ -0,0 +1,650 @@
+// Package asm implements an assembler for the tmach instruction set.
+//
+// A source file contains one statement per line:
+//
+//	loop:   SUB  R1, R1, R2   ; comments start with ';' or '#'
+//	        JNZ  loop
+//
+// Labels name the address of the next instruction and may be used wherever
+// an address or immediate is expected. Addresses count instruction words,
+// the unit of tmach.VM.PC. Numeric literals are decimal or use the 0x, 0o
+// and 0b prefixes, and may contain underscores.
+//
+// The directive ".word v, ..." emits raw 32-bit words.
+//
+// Code goes in the text section, which starts at address 0. After a ".data"
+// directive, statements go in the data section instead, until the next
+// ".text". The data section is placed after the code, aligned to 32 bytes,
+// and its labels name byte addresses, suitable for the address registers.
+// Data directives are:
+//
+//	.byte v, ...    8-bit values
+//	.word v, ...    32-bit big-endian values
+//	.u256 v, ...    256-bit big-endian values, in the layout of LOAD and STORE
+//	.space n        n zero bytes
+//
+// ".entry label" sets the program's entry point, which defaults to address 0.
+//
+// "LDI Rd, value" accepts any value up to 256 bits. Values that do not fit
+// in the 20-bit immediate field are assembled as "LDW Rd, N" followed by N
+// literal words; negative values are stored in two's complement.
+package asm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/xtaci/tmach"
+	"github.com/xtaci/tmach/obj"
+)
+
+// Error is an assembly error at a source position.
+type Error struct {
+	Line int // 1-based line number
+	Col  int // 1-based column number
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+// Program is the result of assembling a source file.
+type Program struct {
+	// Code holds the encoded instructions, to be loaded at address 0.
+	Code []uint32
+	// Data holds the data section, to be loaded at byte address DataAddr.
+	Data     []byte
+	DataAddr uint32
+	// Entry is the instruction address where execution starts.
+	Entry uint32
+	// Labels maps each label to its address: instruction words for code
+	// labels, bytes for data labels.
+	Labels map[string]uint32
+	// Lines maps the address of each instruction to its source line.
+	Lines []obj.Line
+
+	data map[string]bool // data labels
+}
+
+// Bytes returns the program as big-endian words, the layout expected in
+// tmach.VM.Memory.
+func (p *Program) Bytes() []byte {
+	buf := make([]byte, len(p.Code)*4)
+	for i, w := range p.Code {
+		binary.BigEndian.PutUint32(buf[i*4:], w)
+	}
+	return buf
+}
+
+// Object returns the program as an object file. The source name is recorded
+// in its debug info.
+func (p *Program) Object(source string) *obj.File {
+	f := &obj.File{
+		Entry:    p.Entry,
+		Sections: []obj.Section{{Name: ".text", Kind: obj.Code, Addr: 0, Data: p.Bytes()}},
+		Debug:    &obj.Debug{Source: source, Lines: p.Lines},
+	}
+	if len(p.Data) > 0 {
+		f.Sections = append(f.Sections, obj.Section{Name: ".data", Kind: obj.Data, Addr: p.DataAddr, Data: p.Data})
+	}
+	for name, v := range p.Labels {
+		kind := obj.Code
+		if p.data[name] {
+			kind = obj.Data
+		}
+		f.Symbols = append(f.Symbols, obj.Symbol{Name: name, Kind: kind, Value: v})
+	}
+	sort.Slice(f.Symbols, func(i, j int) bool {
+		a, b := f.Symbols[i], f.Symbols[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Value != b.Value {
+			return a.Value < b.Value
+		}
+		return a.Name < b.Name
+	})
+	return f
+}
+
+// Assemble assembles the source text into a program.
+func Assemble(src []byte) (*Program, error) {
+	a := &assembler{labels: make(map[string]uint32), data: make(map[string]bool)}
+	if err := a.parse(string(src)); err != nil {
+		return nil, err
+	}
+	p := &Program{Code: make([]uint32, 0, a.size), Labels: a.labels, data: a.data}
+	p.DataAddr = (a.size*tmach.InstructionSize + dataAlign - 1) &^ (dataAlign - 1)
+	for name := range a.data {
+		a.labels[name] += p.DataAddr
+	}
+	for _, st := range a.stmts {
+		if st.data {
+			b, err := a.encodeData(st)
+			if err != nil {
+				return nil, err
+			}
+			p.Data = append(p.Data, b...)
+			continue
+		}
+		words, err := a.encode(st)
+		if err != nil {
+			return nil, err
+		}
+		if st.mnemonic != ".WORD" {
+			p.Lines = append(p.Lines, obj.Line{PC: uint32(len(p.Code)), Line: uint32(st.line)})
+		}
+		p.Code = append(p.Code, words...)
+	}
+	if a.entry != nil {
+		entry, err := a.value(*a.entry, a.entry.args[0], 32)
+		if err != nil {
+			return nil, err
+		}
+		if a.entry.args[0].kind == argLabel && a.data[a.entry.args[0].name] {
+			return nil, &Error{a.entry.line, a.entry.args[0].col, fmt.Sprintf("entry point %q is a data label", a.entry.args[0].name)}
+		}
+		p.Entry = entry
+	}
+	return p, nil
+}
+
+// dataAlign is the alignment of the data section in bytes, the size of a
+// register in memory.
+const dataAlign = 32
+
+// ===================================================================
+// Parsing
+// ===================================================================
+
+// argKind classifies a parsed operand.
+type argKind int
+
+const (
+	argReg     argKind = iota // R0-R7, F0-F7, A0-A7
+	argMem                    // [Ax]
+	argNum                    // numeric literal
+	argLabel                  // label reference
+	argSpecial                // special register such as SP
+)
+
+// arg is a parsed instruction operand.
+type arg struct {
+	kind  argKind
+	col   int
+	class byte // register class: 'R', 'F' or 'A'
+	reg   uint32
+	num   *big.Int
+	name  string // label or special register name
+}
+
+// stmt is a parsed instruction or directive.
+type stmt struct {
+	line     int
+	col      int
+	mnemonic string
+	args     []arg
+	data     bool   // in the data section
+	addr     uint32 // instruction address, in the text section
+}
+
+type assembler struct {
+	stmts    []stmt
+	labels   map[string]uint32
+	data     map[string]bool // labels in the data section
+	size     uint32          // number of words emitted so far
+	dataSize uint32          // number of data bytes emitted so far
+	inData   bool            // statements go to the data section
+	entry    *stmt           // .entry directive
+}
+
+func (a *assembler) parse(src string) error {
+	for i, line := range strings.Split(src, "\n") {
+		lineno := i + 1
+		toks, err := tokenize(line, lineno)
+		if err != nil {
+			return err
+		}
+
+		// Leading labels.
+		for len(toks) >= 2 && toks[0].kind == tokIdent && toks[1].text == ":" {
+			name := toks[0].text
+			if _, ok := a.labels[name]; ok {
+				return &Error{lineno, toks[0].col, fmt.Sprintf("label %q redefined", name)}
+			}
+			if _, _, ok := register(name); ok || isSpecial(name) {
+				return &Error{lineno, toks[0].col, fmt.Sprintf("register name %q used as label", name)}
+			}
+			if a.inData {
+				a.labels[name] = a.dataSize
+				a.data[name] = true
+			} else {
+				a.labels[name] = a.size
+			}
+			toks = toks[2:]
+		}
+		if len(toks) == 0 {
+			continue
+		}
+
+		if toks[0].kind != tokIdent {
+			return &Error{lineno, toks[0].col, fmt.Sprintf("expected instruction, found %q", toks[0].text)}
+		}
+		st := stmt{line: lineno, col: toks[0].col, mnemonic: strings.ToUpper(toks[0].text)}
+		args, err := parseArgs(toks[1:], lineno)
+		if err != nil {
+			return err
+		}
+		st.args = args
+
+		switch st.mnemonic {
+		case ".TEXT", ".DATA":
+			if len(args) != 0 {
+				return &Error{lineno, args[0].col, fmt.Sprintf("%s takes no operands", strings.ToLower(st.mnemonic))}
+			}
+			a.inData = st.mnemonic == ".DATA"
+			continue
+		case ".ENTRY":
+			if len(args) != 1 || args[0].kind != argLabel && args[0].kind != argNum {
+				return &Error{lineno, st.col, ".entry expects an address"}
+			}
+			if a.entry != nil {
+				return &Error{lineno, st.col, "entry point redefined"}
+			}
+			a.entry = &st
+			continue
+		}
+
+		if a.inData {
+			n, err := st.dataSize()
+			if err != nil {
+				return err
+			}
+			st.data = true
+			a.dataSize += n
+		} else {
+			if dataDirectives[st.mnemonic] {
+				return &Error{lineno, st.col, fmt.Sprintf("%s is only allowed in .data", strings.ToLower(st.mnemonic))}
+			}
+			st.addr = a.size
+			a.size += st.size()
+		}
+		a.stmts = append(a.stmts, st)
+	}
+	return nil
+}
+
+// dataDirectives lists the directives only valid in the data section.
+var dataDirectives = map[string]bool{".BYTE": true, ".U256": true, ".SPACE": true}
+
+// dataSize returns the number of bytes a data section statement emits.
+func (st *stmt) dataSize() (uint32, error) {
+	switch st.mnemonic {
+	case ".BYTE":
+		return uint32(len(st.args)), nil
+	case ".WORD":
+		return 4 * uint32(len(st.args)), nil
+	case ".U256":
+		return 32 * uint32(len(st.args)), nil
+	case ".SPACE":
+		if len(st.args) != 1 || st.args[0].kind != argNum || !st.args[0].num.IsUint64() || st.args[0].num.Uint64() > 1<<24 {
+			return 0, &Error{st.line, st.col, ".space expects a size up to 16777216"}
+		}
+		return uint32(st.args[0].num.Uint64()), nil
+	}
+	return 0, &Error{st.line, st.col, fmt.Sprintf("%s is not allowed in .data", st.mnemonic)}
+}
+
+// size returns the number of words the statement assembles to.
+func (st *stmt) size() uint32 {
+	if st.mnemonic == ".WORD" {
+		return uint32(len(st.args))
+	}
+	if lit := st.wideLiteral(); lit != nil {
+		return 1 + uint32(len(lit))
+	}
+	return 1
+}
+
+// wideLiteral returns the literal words of an "LDI Rd, value" whose value
+// does not fit in the 20-bit immediate field, or nil for any other
+// statement. Values outside the 256-bit range are left for encode to reject.
+func (st *stmt) wideLiteral() []uint32 {
+	if st.mnemonic != "LDI" || len(st.args) != 2 ||
+		st.args[0].kind != argReg || st.args[0].class != 'R' || st.args[1].kind != argNum {
+		return nil
+	}
+	n := st.args[1].num
+	if n.Sign() >= 0 && n.BitLen() <= int(tmach.FieldImm20.Width) {
+		return nil
+	}
+	if n.Sign() >= 0 && n.BitLen() > 256 || n.Sign() < 0 && new(big.Int).Not(n).BitLen() > 255 {
+		return nil
+	}
+	v := new(big.Int).And(n, mask256) // two's complement for negative values
+	lit := make([]uint32, (v.BitLen()+31)/32)
+	for i := len(lit) - 1; i >= 0; i-- {
+		lit[i] = uint32(v.Uint64())
+		v.Rsh(v, 32)
+	}
+	return lit
+}
+
+// mask256 is 2^256-1.
+var mask256 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// parseArgs parses a comma-separated operand list.
+func parseArgs(toks []token, line int) ([]arg, error) {
+	var args []arg
+	for len(toks) > 0 {
+		t := toks[0]
+		var a arg
+		switch {
+		case t.text == "[":
+			if len(toks) < 3 || toks[1].kind != tokIdent || toks[2].text != "]" {
+				return nil, &Error{line, t.col, "malformed memory operand, expected [Ax]"}
+			}
+			class, reg, ok := register(toks[1].text)
+			if !ok || class != 'A' {
+				return nil, &Error{line, toks[1].col, fmt.Sprintf("expected address register, found %q", toks[1].text)}
+			}
+			a = arg{kind: argMem, col: t.col, class: class, reg: reg}
+			toks = toks[3:]
+		case t.kind == tokIdent:
+			if class, reg, ok := register(t.text); ok {
+				a = arg{kind: argReg, col: t.col, class: class, reg: reg}
+			} else if isSpecial(t.text) {
+				a = arg{kind: argSpecial, col: t.col, name: strings.ToUpper(t.text)}
+			} else if isRegisterLike(t.text) {
+				return nil, &Error{line, t.col, fmt.Sprintf("invalid register %q", t.text)}
+			} else {
+				a = arg{kind: argLabel, col: t.col, name: t.text}
+			}
+			toks = toks[1:]
+		case t.kind == tokNumber:
+			n, ok := new(big.Int).SetString(t.text, 0)
+			if !ok {
+				return nil, &Error{line, t.col, fmt.Sprintf("invalid number %q", t.text)}
+			}
+			a = arg{kind: argNum, col: t.col, num: n}
+			toks = toks[1:]
+		default:
+			return nil, &Error{line, t.col, fmt.Sprintf("unexpected %q", t.text)}
+		}
+		args = append(args, a)
+
+		if len(toks) == 0 {
+			break
+		}
+		if toks[0].text != "," {
+			return nil, &Error{line, toks[0].col, fmt.Sprintf("expected ',', found %q", toks[0].text)}
+		}
+		if len(toks) == 1 {
+			return nil, &Error{line, toks[0].col, "missing operand after ','"}
+		}
+		toks = toks[1:]
+	}
+	return args, nil
+}
+
+// register parses a register name such as R3, F0 or A7.
+func register(s string) (class byte, reg uint32, ok bool) {
+	if len(s) != 2 || s[1] < '0' || s[1] > '7' {
+		return 0, 0, false
+	}
+	switch c := s[0] &^ 0x20; c { // upper-case
+	case 'R', 'F', 'A':
+		return c, uint32(s[1] - '0'), true
+	}
+	return 0, 0, false
+}
+
+// isSpecial reports whether s names a special register.
+func isSpecial(s string) bool {
+	for _, name := range specialRegisters {
+		if strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRegisterLike reports whether s looks like a register with an invalid
+// number, such as R8 or A12.
+func isRegisterLike(s string) bool {
+	if len(s) < 2 {
+		return false
+	}
+	switch s[0] &^ 0x20 {
+	case 'R', 'F', 'A':
+	default:
+		return false
+	}
+	for _, c := range s[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ===================================================================
+// Encoding
+// ===================================================================
+
+func (a *assembler) encode(st stmt) ([]uint32, error) {
+	if st.mnemonic == ".WORD" {
+		if len(st.args) == 0 {
+			return nil, &Error{st.line, st.col, ".word expects at least one value"}
+		}
+		words := make([]uint32, len(st.args))
+		for i, arg := range st.args {
+			v, err := a.value(st, arg, 32)
+			if err != nil {
+				return nil, err
+			}
+			words[i] = v
+		}
+		return words, nil
+	}
+
+	if lit := st.wideLiteral(); lit != nil {
+		header := uint32(tmach.OP_LDW)<<24 | st.args[0].reg<<tmach.FieldRd.Shift | uint32(len(lit)-1)
+		return append([]uint32{header}, lit...), nil
+	}
+
+	candidates := lookup(st.mnemonic)
+	if len(candidates) == 0 {
+		return nil, &Error{st.line, st.col, fmt.Sprintf("unknown instruction %q", st.mnemonic)}
+	}
+
+	// Pick the first form whose operands all match; otherwise report the
+	// mismatch of the form that matched the longest operand prefix.
+	var best *form
+	bestMatched := -1
+	for i := range candidates {
+		f := &candidates[i]
+		if len(f.Operands) != len(st.args) {
+			continue
+		}
+		n := 0
+		for n < len(st.args) && accepts(f.Operands[n], st.args[n]) {
+			n++
+		}
+		if n == len(st.args) {
+			return a.encodeForm(st, f)
+		}
+		if n > bestMatched {
+			best, bestMatched = f, n
+		}
+	}
+	if best == nil {
+		return nil, &Error{st.line, st.col, fmt.Sprintf("wrong number of operands for %s", st.mnemonic)}
+	}
+	expected := best.Operands[bestMatched].Kind.String()
+	if best.Operands[bestMatched].Kind == tmach.OperandFixed {
+		expected = best.Operands[bestMatched].Name
+	}
+	return nil, &Error{st.line, st.args[bestMatched].col,
+		fmt.Sprintf("%s expects %s as operand %d", st.mnemonic, expected, bestMatched+1)}
+}
+
+// encodeData encodes a data section directive.
+func (a *assembler) encodeData(st stmt) ([]byte, error) {
+	if st.mnemonic == ".SPACE" {
+		n, _ := st.dataSize()
+		return make([]byte, n), nil
+	}
+	if len(st.args) == 0 {
+		return nil, &Error{st.line, st.col, fmt.Sprintf("%s expects at least one value", strings.ToLower(st.mnemonic))}
+	}
+	var buf []byte
+	for _, arg := range st.args {
+		switch st.mnemonic {
+		case ".BYTE":
+			v, err := a.value(st, arg, 8)
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, byte(v))
+		case ".WORD":
+			v, err := a.value(st, arg, 32)
+			if err != nil {
+				return nil, err
+			}
+			buf = binary.BigEndian.AppendUint32(buf, v)
+		case ".U256":
+			n, err := a.resolve(st, arg)
+			if err != nil {
+				return nil, err
+			}
+			if n.Sign() >= 0 && n.BitLen() > 256 || n.Sign() < 0 && new(big.Int).Not(n).BitLen() > 255 {
+				return nil, &Error{st.line, arg.col, fmt.Sprintf("value %v does not fit in 256 bits", n)}
+			}
+			var b [32]byte
+			new(big.Int).And(n, mask256).FillBytes(b[:])
+			buf = append(buf, b[:]...)
+		}
+	}
+	return buf, nil
+}
+
+// accepts reports whether the operand o can take the parsed arg.
+func accepts(o tmach.Operand, a arg) bool {
+	switch o.Kind {
+	case tmach.OperandR:
+		return a.kind == argReg && a.class == 'R'
+	case tmach.OperandF:
+		return a.kind == argReg && a.class == 'F'
+	case tmach.OperandA:
+		return a.kind == argReg && a.class == 'A'
+	case tmach.OperandMem:
+		return a.kind == argMem
+	case tmach.OperandImm, tmach.OperandSimm, tmach.OperandCount, tmach.OperandAddr, tmach.OperandRel:
+		return a.kind == argNum || a.kind == argLabel
+	case tmach.OperandFixed:
+		return a.kind == argSpecial && a.name == o.Name
+	}
+	return false
+}
+
+func (a *assembler) encodeForm(st stmt, f *form) ([]uint32, error) {
+	word := f.opcode << 24
+	for i, o := range f.Operands {
+		arg := st.args[i]
+		var v uint32
+		switch o.Kind {
+		case tmach.OperandR, tmach.OperandA, tmach.OperandMem:
+			v = arg.reg
+		case tmach.OperandF:
+			v = arg.reg + 8
+		case tmach.OperandImm, tmach.OperandAddr:
+			var err error
+			if v, err = a.value(st, arg, o.Fields[0].Width); err != nil {
+				return nil, err
+			}
+		case tmach.OperandSimm:
+			var err error
+			if v, err = a.signedValue(st, arg, o.Fields[0].Width); err != nil {
+				return nil, err
+			}
+		case tmach.OperandRel:
+			var err error
+			if v, err = a.offset(st, arg, o.Fields[0].Width); err != nil {
+				return nil, err
+			}
+		case tmach.OperandCount:
+			var err error
+			if v, err = a.value(st, arg, 4); err != nil {
+				return nil, err
+			}
+			if v < 1 || v > 8 {
+				return nil, &Error{st.line, arg.col, fmt.Sprintf("word count %d out of range 1-8", v)}
+			}
+			v--
+		}
+		for _, fl := range o.Fields {
+			word |= (v & fl.Mask()) << fl.Shift
+		}
+	}
+	return []uint32{word}, nil
+}
+
+// value resolves a numeric or label operand and checks that it fits in an
+// unsigned field of the given width.
+func (a *assembler) value(st stmt, arg arg, width uint) (uint32, error) {
+	n, err := a.resolve(st, arg)
+	if err != nil {
+		return 0, err
+	}
+	if n.Sign() < 0 || n.BitLen() > int(width) {
+		return 0, &Error{st.line, arg.col, fmt.Sprintf("value %v does not fit in %d bits", n, width)}
+	}
+	return uint32(n.Uint64()), nil
+}
+
+// signedValue resolves a numeric or label operand and checks that it fits
+// in a signed field of the given width. The result is in two's complement.
+func (a *assembler) signedValue(st stmt, arg arg, width uint) (uint32, error) {
+	n, err := a.resolve(st, arg)
+	if err != nil {
+		return 0, err
+	}
+	if !n.IsInt64() || n.Int64() < -1<<(width-1) || n.Int64() >= 1<<(width-1) {
+		return 0, &Error{st.line, arg.col, fmt.Sprintf("value %v does not fit in %d signed bits", n, width)}
+	}
+	return uint32(n.Int64()), nil
+}
+
+// offset resolves the operand of a relative branch and checks that it fits
+// in a signed field of the given width. A label is encoded as its distance
+// from the branch; a number is the distance itself.
+func (a *assembler) offset(st stmt, x arg, width uint) (uint32, error) {
+	if x.kind == argLabel {
+		target, err := a.resolve(st, x)
+		if err != nil {
+			return 0, err
+		}
+		x = arg{kind: argNum, col: x.col, num: target.Sub(target, big.NewInt(int64(st.addr)))}
+	}
+	return a.signedValue(st, x, width)
+}
+
+// resolve returns the value of a numeric or label operand.
+func (a *assembler) resolve(st stmt, arg arg) (*big.Int, error) {
+	switch arg.kind {
+	case argNum:
+		return arg.num, nil
+	case argLabel:
+		addr, ok := a.labels[arg.name]
+		if !ok {
+			return nil, &Error{st.line, arg.col, fmt.Sprintf("undefined label %q", arg.name)}
+		}
+		return new(big.Int).SetUint64(uint64(addr)), nil
+	}
+	return nil, &Error{st.line, arg.col, "expected number or label"}
+}
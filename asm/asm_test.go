@@ -0,0 +1,457 @@
+package asm
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xtaci/tmach"
+	"github.com/xtaci/tmach/obj"
+)
+
+// TestEncoding tests the encoding of each instruction format.
+func TestEncoding(t *testing.T) {
+	tests := []struct {
+		src  string
+		word uint32
+	}{
+		{"NOP", 0x00000000},
+		{"LOAD R2, [A1]", 0x08200100},
+		{"LOAD F2, [A1]", 0x08A00100},
+		{"STORE R3, [A2]", 0x09030200},
+		{"ADD R0, R1, R2", 0x01012000},
+		{"add f0, f1, f2", 0x0189A000},
+		{"CMP R0, R1", 0x06001000},
+		{"ITOF F1, R2", 0x07920000},
+		{"FTOI R1, F2", 0x0A1A0000},
+		{"NOT R1, R2", 0x0E120000},
+		{"LSH R1, R2, 3", 0x0F120003},
+		{"LSH R1, 0xff", 0x0F1100FF},
+		{"JMP 0x123456", 0x12123456},
+		{"SYSCALL 0x1234", 0x2D001234},
+		{"HALT", 0x2E000000},
+		{"HALT 3", 0x2E000003},
+		{"JMP A3", 0x32030000},
+		{"JMP J", 0x33000000},
+		{"BRA -2", 0x34FFFFFE},
+		{"BLE 0x10", 0x3C000010},
+		{"SQRT F0, F1", 0x3D890000},
+		{"MOV R3, FPCR", 0x4A300000},
+		{"MOV FPCR, R2", 0x4B020000},
+		{"ADDMOD R0, R1, R2, R3", 0x4C012300},
+		{"INVMOD R4, R5, R6", 0x50456000},
+		{"POPCNT R1, R2", 0x51120000},
+		{"BSWAP R0, R7", 0x54070000},
+		{"BT R3, R4", 0x55034000},
+		{"BTS R1, R2, R3", 0x56123000},
+		{"BTC R5, R6", 0x58556000},
+		{"BT R3, 255", 0x590300FF},
+		{"BTR R1, 7", 0x5B110007},
+		{"LSH R0, R1, R2", 0x5D012000},
+		{"SAR R3, R4", 0x5F334000},
+		{"ROR R1, R2, 200", 0x611200C8},
+		{"ROL R5, R6", 0x62556000},
+		{"POW F2, F3, F4", 0x49ABC000},
+		{".word 0xdeadbeef", 0xDEADBEEF},
+	}
+	for _, tt := range tests {
+		prog, err := Assemble([]byte(tt.src))
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		if len(prog.Code) != 1 || prog.Code[0] != tt.word {
+			t.Errorf("%q: expected %08X, got %08X", tt.src, tt.word, prog.Code)
+		}
+	}
+}
+
+// TestLabels tests forward and backward label references.
+func TestLabels(t *testing.T) {
+	src := `
+; sum 5 + 4 + 3 + 2 + 1 into R0
+start:
+	JMP loop        # forward reference
+loop:	ADD R0, R0, R1
+	SUB R1, R1, R2
+	JNZ loop
+done:
+`
+	prog, err := Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prog.Labels["loop"] != 1 || prog.Labels["done"] != 4 {
+		t.Errorf("unexpected labels: %v", prog.Labels)
+	}
+	if prog.Code[0] != 0x12000001 || prog.Code[3] != 0x14000001 {
+		t.Errorf("unexpected jump encodings: %08X", prog.Code)
+	}
+
+	vm := tmach.NewVM()
+	vm.R[1].SetInt64(5)
+	vm.R[2].SetInt64(1)
+	vm.LoadProgram(prog.Code)
+	for vm.PC < prog.Labels["done"] {
+		if err := vm.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if vm.R[0].Cmp(big.NewInt(15)) != 0 {
+		t.Errorf("expected R0 = 15, got %v", vm.R[0])
+	}
+}
+
+// TestErrors tests that errors report their line and column.
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		src       string
+		line, col int
+	}{
+		{"FOO R1", 1, 1},
+		{"NOP\n  ADD R0, F1, R2", 2, 11},
+		{"ADD R0, R1", 1, 1},
+		{"LOAD R0, [R1]", 1, 11},
+		{"ADD R0, R8, R1", 1, 9},
+		{"JMP nowhere", 1, 5},
+		{"JMP 0x1000000", 1, 5},
+		{"LSH R0, R1, 256", 1, 13},
+		{"x: NOP\nx: NOP", 2, 1},
+		{"ADD R0, R1, R2,", 1, 15},
+		{"ADD R0 R1", 1, 8},
+		{"NOP @", 1, 5},
+		{".data\nNOP", 2, 1},
+		{".byte 1", 1, 1},
+		{".data\n.byte 256", 2, 7},
+		{".data\nx: .byte 1\n.text\n.entry x", 4, 8},
+	}
+	for _, tt := range tests {
+		_, err := Assemble([]byte(tt.src))
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("%q: expected *Error, got %v", tt.src, err)
+			continue
+		}
+		if e.Line != tt.line || e.Col != tt.col {
+			t.Errorf("%q: expected error at %d:%d, got %v", tt.src, tt.line, tt.col, e)
+		}
+	}
+}
+
+// TestDisassemble tests that disassembly round-trips through the assembler.
+func TestDisassemble(t *testing.T) {
+	tests := []struct {
+		word uint32
+		text string
+	}{
+		{0x00000000, "NOP"},
+		{0x08200100, "LOAD R2, [A1]"},
+		{0x090B0200, "STORE F3, [A2]"},
+		{0x0189A000, "ADD F0, F1, F2"},
+		{0x07920000, "ITOF F1, R2"},
+		{0x0F120003, "LSH R1, R2, 3"},
+		{0x12123456, "JMP 0x123456"},
+		{0x2D000001, "SYSCALL 1"},
+		{0x2E000000, "HALT"},
+		{0x2E0000FF, "HALT 255"},
+		{0x32070000, "JMP A7"},
+		{0x33000000, "JMP J"},
+		{0x35800000, "BZ -8388608"},
+	}
+	for _, tt := range tests {
+		text, err := Disassemble(tt.word)
+		if err != nil || text != tt.text {
+			t.Errorf("%08X: expected %q, got %q (%v)", tt.word, tt.text, text, err)
+		}
+	}
+
+	// Every word with a known opcode and any operand bits must either be
+	// rejected or round-trip exactly.
+	for opcode := uint32(0); opcode < 256; opcode++ {
+		for _, operands := range []uint32{0, 0x123456, 0x012000, 0x89A000, 0x110005, 0xFFFFFF} {
+			word := opcode<<24 | operands
+			text, err := Disassemble(word)
+			if err != nil {
+				continue
+			}
+			prog, err := Assemble([]byte(text))
+			if err != nil {
+				t.Errorf("%08X: %q does not assemble: %v", word, text, err)
+				continue
+			}
+			if prog.Code[0] != word {
+				t.Errorf("%08X: %q assembles to %08X", word, text, prog.Code[0])
+			}
+		}
+	}
+
+	for _, word := range []uint32{0xFF000000, 0x01812000, 0x00000001} {
+		if text, err := Disassemble(word); err == nil {
+			t.Errorf("%08X: expected error, got %q", word, text)
+		}
+	}
+}
+
+// TestBranch tests that relative branches reach their labels wherever the
+// code is loaded, and that JMP J returns from a jump.
+func TestBranch(t *testing.T) {
+	src := `
+	LDI R0, 3
+	LDI R1, 1
+loop:	SUB R0, R0, R1
+	BNZ loop
+	BRA sub         ; call sub, which returns through J
+	BRA done
+sub:	LDI R2, 42
+	JMP J
+done:	HALT
+`
+	prog, err := Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prog.Code[3] != tmach.OP_BNZ<<24|0xFFFFFF || prog.Code[4] != tmach.OP_BRA<<24|2 {
+		t.Errorf("unexpected branch encodings %08X, %08X", prog.Code[3], prog.Code[4])
+	}
+
+	// Move the program away from address 0.
+	const base = 100
+	vm := tmach.NewVM()
+	vm.LoadProgram(append(make([]uint32, base), prog.Code...))
+	vm.PC = base
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if vm.PC != base+prog.Labels["done"] || vm.R[2].Int64() != 42 {
+		t.Errorf("expected to halt at done with R2 = 42, got PC = %d, R2 = %v", vm.PC, vm.R[2])
+	}
+
+	var buf strings.Builder
+	if err := Dump(&buf, prog.Code[3:5], 3); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"BNZ -1", "; 000003: 36FFFFFF -> 000002", "BRA 2", "-> 000006"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("listing lacks %q:\n%s", want, buf.String())
+		}
+	}
+
+	if _, err := Assemble([]byte("BRA far\n.space 1\n")); err == nil {
+		t.Error("expected error for a branch to an undefined label")
+	}
+}
+
+// TestDump tests that a listing reassembles to the original words.
+func TestDump(t *testing.T) {
+	words := []uint32{0x01012000, 0xFF000000, 0x12000000}
+	var buf strings.Builder
+	if err := Dump(&buf, words, 0x10); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "; 000011: FF000000") {
+		t.Errorf("listing lacks annotation:\n%s", buf.String())
+	}
+	prog, err := Assemble([]byte(buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(prog.Code, words) {
+		t.Errorf("expected %08X, got %08X", words, prog.Code)
+	}
+}
+
+// TestLoadImmediate tests LDI, including wide literals, and address arithmetic.
+func TestLoadImmediate(t *testing.T) {
+	tests := []struct {
+		src   string
+		words []uint32
+	}{
+		{"LDI R1, 0xFFFFF", []uint32{0x181FFFFF}},
+		{"LDI F1, 2", []uint32{0x18900002}},
+		{"LDI A3, 0x100", []uint32{0x1E300100}},
+		{"LDI R1, 0x100000", []uint32{0x19100000, 0x00100000}},
+		{"LDI R2, 0x1_00000000", []uint32{0x19200001, 0x00000001, 0x00000000}},
+		{"LDI R0, -1", []uint32{0x19000007, ^uint32(0), ^uint32(0), ^uint32(0), ^uint32(0), ^uint32(0), ^uint32(0), ^uint32(0), ^uint32(0)}},
+		{"LDW R0, 2\n.word 1, 2", []uint32{0x19000001, 1, 2}},
+		{"MOV R1, R2", []uint32{0x1A120000}},
+		{"MOV R1, F2", []uint32{0x1A1A0000}},
+		{"MOV F1, R2", []uint32{0x1A920000}},
+		{"MOV A1, R2", []uint32{0x1B120000}},
+		{"MOV R1, A2", []uint32{0x1C120000}},
+		{"MOV A1, A2", []uint32{0x1D120000}},
+		{"ADD A1, A1, -32", []uint32{0x1F11FFE0}},
+		{"ADD A1, A2, R3", []uint32{0x20123000}},
+	}
+	for _, tt := range tests {
+		prog, err := Assemble([]byte(tt.src))
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(prog.Code, tt.words) {
+			t.Errorf("%q: expected %08X, got %08X", tt.src, tt.words, prog.Code)
+		}
+	}
+
+	for _, src := range []string{"LDI F0, 0x100000", "LDW R0, 9", "ADD A0, A0, 32768", "LDI R0, 1<<256"} {
+		if _, err := Assemble([]byte(src)); err == nil {
+			t.Errorf("%q: expected error", src)
+		}
+	}
+
+	// Labels after a wide literal account for its size.
+	prog, err := Assemble([]byte("LDI R0, 0x123456789\nend: JMP end"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prog.Labels["end"] != 3 || prog.Code[3] != 0x12000003 {
+		t.Errorf("unexpected label address %d", prog.Labels["end"])
+	}
+}
+
+// TestSelfContained tests a program that needs no host set-up.
+func TestSelfContained(t *testing.T) {
+	src := `
+	LDI A0, 0x1000
+	LDI R0, 0x123456789ABCDEF0123456789
+	STORE R0, [A0]
+	ADD A1, A0, 32
+	LOAD R1, [A0]
+	MOV R2, A1
+`
+	prog, err := Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := tmach.NewVM()
+	vm.LoadProgram(prog.Code)
+	for vm.PC < uint32(len(prog.Code)) {
+		if err := vm.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, _ := new(big.Int).SetString("0x123456789ABCDEF0123456789", 0)
+	if vm.R[1].Cmp(expected) != 0 || vm.R[2].Int64() != 0x1020 {
+		t.Errorf("unexpected result R1 = %x, R2 = %x", vm.R[1], vm.R[2])
+	}
+}
+
+// TestCall tests nested calls through the hardware stack.
+func TestCall(t *testing.T) {
+	src := `
+	LDI R0, 3
+	CALL fact       ; R1 = 3!
+	JMP done
+
+; fact computes R1 = R0! recursively, preserving R0.
+fact:	LDI R2, 1
+	CMP R0, R2
+	JGT recurse
+	LDI R1, 1
+	RET
+recurse:
+	PUSH R0
+	SUB R0, R0, R2
+	CALL fact
+	POP R0
+	MUL R1, R1, R0
+	RET
+
+done:	MOV A0, SP
+`
+	prog, err := Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := tmach.NewVM()
+	vm.LoadProgram(prog.Code)
+	for vm.PC != prog.Labels["done"]+1 {
+		if err := vm.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if vm.R[1].Int64() != 6 || vm.R[0].Int64() != 3 {
+		t.Errorf("expected R1 = 6 and R0 = 3, got %v and %v", vm.R[1], vm.R[0])
+	}
+	if vm.A[0] != vm.StackBase {
+		t.Errorf("stack not balanced: SP = %#x, base %#x", vm.A[0], vm.StackBase)
+	}
+}
+
+// TestData tests the data section and the object file produced from it.
+func TestData(t *testing.T) {
+	src := `
+	.entry start
+	.data
+count:	.u256 5
+	.word 0xDEADBEEF
+bytes:	.byte 1, 2
+	.space 3
+	.text
+	NOP
+start:	LDI A0, count
+	LOAD R0, [A0]
+`
+	prog, err := Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prog.Entry != 1 || prog.DataAddr != 32 || len(prog.Data) != 41 {
+		t.Fatalf("unexpected layout: entry %d, data %d bytes at %d", prog.Entry, len(prog.Data), prog.DataAddr)
+	}
+	if prog.Labels["count"] != 32 || prog.Labels["bytes"] != 68 || prog.Data[31] != 5 || prog.Data[36] != 1 {
+		t.Errorf("unexpected data labels %v or contents %x", prog.Labels, prog.Data)
+	}
+
+	f := prog.Object("test.s")
+	if sym, ok := f.Lookup("bytes"); !ok || sym.Kind != obj.Data || sym.Value != 68 {
+		t.Errorf("unexpected symbol %+v", sym)
+	}
+	if f.Debug.Lines[1] != (obj.Line{PC: 1, Line: 10}) {
+		t.Errorf("unexpected line info %v", f.Debug.Lines)
+	}
+	vm := tmach.NewVM()
+	if err := f.Load(vm); err != nil {
+		t.Fatal(err)
+	}
+	for vm.PC < uint32(len(prog.Code)) {
+		if err := vm.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if vm.R[0].Int64() != 5 {
+		t.Errorf("expected R0 = 5, got %v", vm.R[0])
+	}
+}
+
+// TestDumpObject tests that an object file listing reassembles to the same
+// object.
+func TestDumpObject(t *testing.T) {
+	src := `
+	.entry main
+	.data
+msg:	.byte 104, 105
+	.text
+main:	LDI A0, msg
+	LDI R0, 0x123456789ABCDEF
+end:
+`
+	prog, err := Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf strings.Builder
+	if err := DumpObject(&buf, prog.Object("")); err != nil {
+		t.Fatal(err)
+	}
+	again, err := Assemble([]byte(buf.String()))
+	if err != nil {
+		t.Fatalf("%v in listing:\n%s", err, buf.String())
+	}
+	if !reflect.DeepEqual(again.Code, prog.Code) || !reflect.DeepEqual(again.Data, prog.Data) ||
+		!reflect.DeepEqual(again.Labels, prog.Labels) || again.Entry != prog.Entry {
+		t.Errorf("listing does not round-trip:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,224 @@
+package asm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/xtaci/tmach"
+	"github.com/xtaci/tmach/obj"
+)
+
+// Disassemble returns the assembly text of an encoded instruction word. The
+// result assembles back to the same word. It returns an error if the opcode
+// is unknown or the word does not match any instruction form, for example
+// because reserved bits are set.
+func Disassemble(word uint32) (string, error) {
+	opcode := word >> 24
+	known := false
+	for i := range forms {
+		f := &forms[i]
+		if f.opcode != opcode {
+			continue
+		}
+		known = true
+		if s, ok := f.disassemble(word); ok {
+			return s, nil
+		}
+	}
+	if !known {
+		return "", fmt.Errorf("unknown opcode 0x%02X", opcode)
+	}
+	return "", fmt.Errorf("invalid operands for opcode 0x%02X in 0x%08X", opcode, word)
+}
+
+// disassemble decodes word using the form, reporting false if the operand
+// fields are not valid for it.
+func (f *form) disassemble(word uint32) (string, bool) {
+	used := uint32(0xFF) << 24
+	args := make([]string, len(f.Operands))
+	for i, o := range f.Operands {
+		var v uint32
+		if len(o.Fields) > 0 {
+			v = o.Fields[0].Get(word)
+		}
+		for _, fl := range o.Fields {
+			if fl.Get(word) != v {
+				return "", false
+			}
+			used |= fl.Mask() << fl.Shift
+		}
+		switch o.Kind {
+		case tmach.OperandR:
+			if v >= 8 {
+				return "", false
+			}
+			args[i] = fmt.Sprintf("R%d", v)
+		case tmach.OperandF:
+			if v < 8 {
+				return "", false
+			}
+			args[i] = fmt.Sprintf("F%d", v-8)
+		case tmach.OperandA:
+			if v >= 8 {
+				return "", false
+			}
+			args[i] = fmt.Sprintf("A%d", v)
+		case tmach.OperandMem:
+			if v >= 8 {
+				return "", false
+			}
+			args[i] = fmt.Sprintf("[A%d]", v)
+		case tmach.OperandImm:
+			args[i] = fmt.Sprint(v)
+		case tmach.OperandSimm, tmach.OperandRel:
+			w := o.Fields[0].Width
+			args[i] = fmt.Sprint(int32(v<<(32-w)) >> (32 - w))
+		case tmach.OperandCount:
+			args[i] = fmt.Sprint(v + 1)
+		case tmach.OperandFixed:
+			args[i] = o.Name
+		case tmach.OperandAddr:
+			args[i] = fmt.Sprintf("0x%06X", v)
+		}
+	}
+	if word&^used != 0 {
+		return "", false
+	}
+	if len(args) == 0 {
+		return f.Mnemonic, true
+	}
+	return f.Mnemonic + " " + strings.Join(args, ", "), true
+}
+
+// Dump writes an annotated listing of words, the first of which is at
+// instruction address addr. Every line is valid assembly: words that do not
+// decode, and the literal words following LDW, are emitted as .word
+// directives, and the address and raw encoding follow in a comment, with
+// the target address of relative branches.
+func Dump(w io.Writer, words []uint32, addr uint32) error {
+	return dump(w, words, addr, nil)
+}
+
+// dump is Dump, emitting the given labels before the instructions they name.
+func dump(w io.Writer, words []uint32, addr uint32, labels map[uint32][]string) error {
+	literals := 0
+	for i, word := range words {
+		if err := writeLabels(w, labels[addr+uint32(i)]); err != nil {
+			return err
+		}
+		var text, note string
+		if literals > 0 {
+			text = fmt.Sprintf(".word 0x%08X", word)
+			literals--
+		} else if s, err := Disassemble(word); err != nil {
+			text = fmt.Sprintf(".word 0x%08X", word)
+			note = " (" + err.Error() + ")"
+		} else {
+			text = s
+			if word>>24 == tmach.OP_LDW {
+				literals = int(word&7) + 1
+			}
+			if isBranch(word) {
+				note = fmt.Sprintf(" -> %06X", addr+uint32(i)+uint32(tmach.Decode(word).Offset))
+			}
+		}
+		if _, err := fmt.Fprintf(w, "\t%-24s ; %06X: %08X%s\n", text, addr+uint32(i), word, note); err != nil {
+			return err
+		}
+	}
+	return writeLabels(w, labels[addr+uint32(len(words))])
+}
+
+// isBranch reports whether word is a PC-relative branch.
+func isBranch(word uint32) bool {
+	for _, f := range forms {
+		if f.opcode == word>>24 && len(f.Operands) == 1 && f.Operands[0].Kind == tmach.OperandRel {
+			return true
+		}
+	}
+	return false
+}
+
+// DumpObject writes an annotated listing of an object file. Code sections
+// are listed as by Dump, data sections as .byte directives, and symbols
+// become labels.
+func DumpObject(w io.Writer, f *obj.File) error {
+	code := make(map[uint32][]string)
+	data := make(map[uint32][]string)
+	entry := fmt.Sprintf("0x%06X", f.Entry)
+	for _, s := range f.Symbols {
+		if s.Kind == obj.Data {
+			data[s.Value] = append(data[s.Value], s.Name)
+			continue
+		}
+		code[s.Value] = append(code[s.Value], s.Name)
+		if s.Value == f.Entry {
+			entry = s.Name
+		}
+	}
+	if _, err := fmt.Fprintf(w, "\t.entry %s\n", entry); err != nil {
+		return err
+	}
+
+	for _, s := range f.Sections {
+		if s.Kind != obj.Code {
+			continue
+		}
+		if s.Addr%tmach.InstructionSize != 0 || len(s.Data)%tmach.InstructionSize != 0 {
+			return fmt.Errorf("code section %s is not word aligned", s.Name)
+		}
+		words := make([]uint32, len(s.Data)/tmach.InstructionSize)
+		for i := range words {
+			words[i] = binary.BigEndian.Uint32(s.Data[i*tmach.InstructionSize:])
+		}
+		if _, err := fmt.Fprintf(w, "\t%-24s ; %s at 0x%06X\n", ".text", s.Name, s.Addr); err != nil {
+			return err
+		}
+		if err := dump(w, words, s.Addr/tmach.InstructionSize, code); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range f.Sections {
+		if s.Kind != obj.Data {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "\t%-24s ; %s at 0x%06X\n", ".data", s.Name, s.Addr); err != nil {
+			return err
+		}
+		for i := 0; i < len(s.Data); {
+			addr := s.Addr + uint32(i)
+			if err := writeLabels(w, data[addr]); err != nil {
+				return err
+			}
+			// A row ends at 8 bytes or at the next label.
+			n := 1
+			for n < 8 && i+n < len(s.Data) && data[addr+uint32(n)] == nil {
+				n++
+			}
+			vals := make([]string, n)
+			for j := range vals {
+				vals[j] = fmt.Sprintf("0x%02X", s.Data[i+j])
+			}
+			if _, err := fmt.Fprintf(w, "\t.byte %s\n", strings.Join(vals, ", ")); err != nil {
+				return err
+			}
+			i += n
+		}
+		if err := writeLabels(w, data[s.Addr+uint32(len(s.Data))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeLabels(w io.Writer, names []string) error {
+	for _, name := range names {
+		if _, err := fmt.Fprintf(w, "%s:\n", name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
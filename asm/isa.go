@@ -0,0 +1,36 @@
+package asm
+
+import "github.com/xtaci/tmach"
+
+// form is one syntactic form of an instruction together with its opcode.
+type form struct {
+	tmach.Form
+	opcode uint32
+}
+
+// forms lists the forms of every opcode in tmach.ISA, in table order. A
+// mnemonic may have several forms, e.g. ADD on integer or on floating-point
+// registers; the first form whose operand kinds match is used.
+var forms = func() []form {
+	var fs []form
+	for _, op := range tmach.ISA {
+		for _, f := range op.Forms {
+			fs = append(fs, form{f, op.Opcode})
+		}
+	}
+	return fs
+}()
+
+// specialRegisters lists the names accepted for tmach.OperandFixed operands.
+var specialRegisters = []string{"SP", "J", "FPCR"}
+
+// lookup returns all forms of the given (upper-case) mnemonic.
+func lookup(mnemonic string) []form {
+	var fs []form
+	for _, f := range forms {
+		if f.Mnemonic == mnemonic {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
@@ -0,0 +1,134 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/xtaci/tmach"
+)
+
+// sampleOperand returns assembly text for an operand of kind o, and the
+// value its fields should hold.
+func sampleOperand(o tmach.Operand) (string, uint32) {
+	var w uint
+	if len(o.Fields) > 0 {
+		w = o.Fields[0].Width
+	}
+	switch o.Kind {
+	case tmach.OperandR:
+		return "R3", 3
+	case tmach.OperandF:
+		return "F5", 13
+	case tmach.OperandA:
+		return "A6", 6
+	case tmach.OperandMem:
+		return "[A2]", 2
+	case tmach.OperandImm:
+		return fmt.Sprint(uint32(1)<<w - 1), 1<<w - 1
+	case tmach.OperandSimm, tmach.OperandRel:
+		return fmt.Sprint(-1 << (w - 1)), 1 << (w - 1)
+	case tmach.OperandCount:
+		return "8", 7
+	case tmach.OperandAddr:
+		return "0xABCDEF", 0xABCDEF
+	}
+	return o.Name, 0
+}
+
+// TestISAConformance tests that every form of tmach.ISA assembles to the
+// encoding the table describes, disassembles back to the same word, and is
+// accepted by VM.Execute.
+func TestISAConformance(t *testing.T) {
+	vm := tmach.NewVM()
+	for _, op := range tmach.ISA {
+		if tmach.Lookup(op.Opcode) != op {
+			t.Errorf("Lookup(0x%02X) does not return its table entry", op.Opcode)
+		}
+		for _, f := range op.Forms {
+			args := make([]string, len(f.Operands))
+			want := op.Opcode << 24
+			for i, o := range f.Operands {
+				var v uint32
+				args[i], v = sampleOperand(o)
+				for _, fl := range o.Fields {
+					want |= v << fl.Shift
+				}
+			}
+			src := strings.TrimSpace(f.Mnemonic + " " + strings.Join(args, ", "))
+			if op.Opcode == tmach.OP_LDW {
+				src += "\n.word 1, 2, 3, 4, 5, 6, 7, 8"
+			}
+			prog, err := Assemble([]byte(src))
+			if err != nil {
+				t.Errorf("%s: %v", f, err)
+				continue
+			}
+			if prog.Code[0] != want {
+				t.Errorf("%s: %q assembled to %08X, expected %08X", f, src, prog.Code[0], want)
+			}
+
+			text, err := Disassemble(want)
+			if err != nil {
+				t.Errorf("%s: %v", f, err)
+				continue
+			}
+			if again, err := Assemble([]byte(text)); err != nil || again.Code[0] != want {
+				t.Errorf("%s: %q does not reassemble to %08X", f, text, want)
+			}
+
+			var fault *tmach.Fault
+			if err := vm.Execute(want); errors.As(err, &fault) && (fault.Kind == tmach.FaultOpcode || fault.Kind == tmach.FaultRegister) {
+				t.Errorf("%s: Execute(%08X) failed: %v", f, want, err)
+			}
+		}
+	}
+}
+
+// TestRegisterFields tests that VM.Execute and Disassemble reject the same
+// register operands: every combination of values of the register fields of
+// each opcode either executes and disassembles, or is a register fault that
+// does not disassemble.
+func TestRegisterFields(t *testing.T) {
+	vm := tmach.NewVM()
+	for _, op := range tmach.ISA {
+		var fields []tmach.Field
+		for _, f := range op.Forms {
+			for _, o := range f.Operands {
+				switch o.Kind {
+				case tmach.OperandR, tmach.OperandF, tmach.OperandA, tmach.OperandMem:
+				default:
+					continue
+				}
+				for _, fl := range o.Fields {
+					if !containsField(fields, fl) {
+						fields = append(fields, fl)
+					}
+				}
+			}
+		}
+
+		for n := 0; n < 1<<(4*len(fields)); n++ {
+			word := op.Opcode << 24
+			for i, fl := range fields {
+				word |= uint32(n>>(4*i)&0xF) << fl.Shift
+			}
+			var fault *tmach.Fault
+			err := vm.Execute(word)
+			rejected := errors.As(err, &fault) && fault.Kind == tmach.FaultRegister
+			if _, derr := Disassemble(word); rejected != (derr != nil) {
+				t.Errorf("%08X: Execute returned %v, Disassemble %v", word, err, derr)
+			}
+		}
+	}
+}
+
+func containsField(fields []tmach.Field, f tmach.Field) bool {
+	for _, g := range fields {
+		if g == f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package asm
+
+import "fmt"
+
+// tokenKind classifies a token.
+type tokenKind int
+
+const (
+	tokIdent  tokenKind = iota // mnemonic, register, label or directive
+	tokNumber                  // numeric literal
+	tokPunct                   // one of , : [ ]
+)
+
+// token is a lexical token of a source line.
+type token struct {
+	kind tokenKind
+	text string
+	col  int // 1-based column
+}
+
+// tokenize splits a source line into tokens, dropping comments.
+func tokenize(line string, lineno int) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == ';' || c == '#':
+			return toks, nil
+		case c == ',' || c == ':' || c == '[' || c == ']':
+			toks = append(toks, token{tokPunct, line[i : i+1], i + 1})
+			i++
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(line) && isIdentChar(line[j]) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, line[i:j], i + 1})
+			i = j
+		case isDigit(c) || ((c == '-' || c == '+') && i+1 < len(line) && isDigit(line[i+1])):
+			j := i + 1
+			for j < len(line) && isIdentChar(line[j]) {
+				j++
+			}
+			toks = append(toks, token{tokNumber, line[i:j], i + 1})
+			i = j
+		default:
+			return nil, &Error{lineno, i + 1, fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	return toks, nil
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '.'
+}
+
+func isIdentChar(c byte) bool { return isIdentStart(c) || isDigit(c) }
@@ -0,0 +1,74 @@
+// Command tmach-asm assembles tmach assembly source into an object file.
+//
+// Usage:
+//
+//	tmach-asm [-o output] [-raw] input.s
+//
+// The output is an object file, as read by tmach-run and tmach-dis. With
+// -raw, the output is instead the code section alone as big-endian 32-bit
+// words, ready to be copied to address 0 of the virtual machine's memory.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xtaci/tmach/asm"
+)
+
+func main() {
+	output := flag.String("o", "", "output file (default: input with .tmo extension, or .bin with -raw)")
+	raw := flag.Bool("raw", false, "write raw code words instead of an object file")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: tmach-asm [-o output] [-raw] input.s\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	input := flag.Arg(0)
+	src, err := os.ReadFile(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	prog, err := asm.Assemble(src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s:%v\n", input, err)
+		os.Exit(1)
+	}
+
+	var data []byte
+	ext := ".tmo"
+	if *raw {
+		if len(prog.Data) > 0 {
+			fmt.Fprintf(os.Stderr, "%s: -raw cannot represent a data section\n", input)
+			os.Exit(1)
+		}
+		data, ext = prog.Bytes(), ".bin"
+	} else {
+		var buf bytes.Buffer
+		if _, err := prog.Object(filepath.Base(input)).WriteTo(&buf); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", input, err)
+			os.Exit(1)
+		}
+		data = buf.Bytes()
+	}
+
+	out := *output
+	if out == "" {
+		out = strings.TrimSuffix(input, filepath.Ext(input)) + ext
+	}
+	if err := os.WriteFile(out, data, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/xtaci/tmach"
+	"github.com/xtaci/tmach/asm"
+	"github.com/xtaci/tmach/obj"
+)
+
+// flagNames lists the status register flags in bit order.
+var flagNames = []struct {
+	name string
+	bit  int
+}{
+	{"ZF", tmach.ZF},
+	{"OF", tmach.OF},
+	{"DF", tmach.DF},
+	{"LT", tmach.LT},
+	{"GT", tmach.GT},
+	{"EQ", tmach.EQ},
+	{"CF", tmach.CF},
+}
+
+// fpFlagNames lists the FPCR flags in bit order.
+var fpFlagNames = []struct {
+	name string
+	bit  int
+}{
+	{"IX", tmach.IX},
+	{"UF", tmach.UF},
+	{"OV", tmach.OV},
+}
+
+// errQuit ends the command loop.
+var errQuit = errors.New("quit")
+
+// watchpoint is a watched range of memory bytes.
+type watchpoint struct {
+	addr, size uint32
+}
+
+// watchMemory wraps the VM's memory to report writes to watched ranges.
+type watchMemory struct {
+	tmach.Memory
+	watches []watchpoint
+	hits    []string // writes to watched ranges since the last reset
+}
+
+func (m *watchMemory) WriteBytes(addr uint32, p []byte) {
+	end := uint64(addr) + uint64(len(p))
+	for _, w := range m.watches {
+		if uint64(w.addr) < end && uint64(addr) < uint64(w.addr)+uint64(w.size) {
+			old := make([]byte, len(p))
+			m.Memory.ReadBytes(addr, old)
+			m.hits = append(m.hits, fmt.Sprintf("watchpoint 0x%08X: %d bytes at 0x%08X\n  old: %X\n  new: %X",
+				w.addr, len(p), addr, old, p))
+			break
+		}
+	}
+	m.Memory.WriteBytes(addr, p)
+}
+
+// debugger holds the state of a debugging session.
+type debugger struct {
+	vm        *tmach.VM
+	file      *obj.File
+	mem       *watchMemory
+	out       io.Writer
+	breaks    map[uint32]bool
+	interrupt atomic.Bool // set to stop a running program
+	last      string      // previous command line, repeated by an empty line
+}
+
+func newDebugger(vm *tmach.VM, f *obj.File, out io.Writer) *debugger {
+	mem := &watchMemory{Memory: vm.Memory}
+	vm.Memory = mem
+	return &debugger{vm: vm, file: f, mem: mem, out: out, breaks: make(map[uint32]bool)}
+}
+
+// command is a debugger command.
+type command struct {
+	name, alias string
+	args, help  string
+	run         func(d *debugger, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"step", "s", "[n]", "execute n instructions (default 1)", (*debugger).step},
+		{"continue", "c", "", "run until a breakpoint, watchpoint, fault or halt", (*debugger).cont},
+		{"break", "b", "[addr]", "set a breakpoint at an instruction address, or list breakpoints", (*debugger).setBreak},
+		{"delete", "d", "addr", "delete the breakpoint at an instruction address", (*debugger).deleteBreak},
+		{"watch", "w", "[addr [size]]", "stop after writes to size bytes (default 32) at addr, or list watchpoints", (*debugger).watch},
+		{"unwatch", "", "addr", "delete the watchpoint at addr", (*debugger).unwatch},
+		{"regs", "r", "", "display registers and flags", (*debugger).regs},
+		{"list", "l", "[addr [n]]", "disassemble n instructions (default 11) around addr (default PC)", (*debugger).list},
+		{"x", "", "addr [n]", "examine n bytes of memory (default 32)", (*debugger).examine},
+		{"help", "h", "", "show this help", (*debugger).help},
+		{"quit", "q", "", "exit the debugger", func(*debugger, []string) error { return errQuit }},
+	}
+}
+
+// repl reads and executes commands until end of input or quit.
+func (d *debugger) repl(in io.Reader) error {
+	d.where()
+	sc := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(d.out, "(tmach) ")
+		if !sc.Scan() {
+			fmt.Fprintln(d.out)
+			return sc.Err()
+		}
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			line = d.last
+		}
+		d.last = line
+		if err := d.exec(line); err != nil {
+			if err == errQuit {
+				return nil
+			}
+			fmt.Fprintln(d.out, "error:", err)
+		}
+	}
+}
+
+// exec executes a command line.
+func (d *debugger) exec(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	for _, c := range commands {
+		if fields[0] == c.name || fields[0] == c.alias {
+			return c.run(d, fields[1:])
+		}
+	}
+	return fmt.Errorf("unknown command %q, try help", fields[0])
+}
+
+// ===================================================================
+// Execution
+// ===================================================================
+
+// stepOne executes one instruction, reporting whether execution should stop.
+func (d *debugger) stepOne() (stop bool) {
+	d.mem.hits = d.mem.hits[:0]
+	err := d.vm.Step()
+	for _, hit := range d.mem.hits {
+		fmt.Fprintln(d.out, hit)
+	}
+	switch {
+	case errors.Is(err, tmach.ErrHalted), err == nil && d.vm.State == tmach.Halted:
+		fmt.Fprintf(d.out, "program halted with exit code %d\n", d.vm.ExitCode)
+		return true
+	case err != nil:
+		fmt.Fprintln(d.out, err)
+		return true
+	}
+	return len(d.mem.hits) > 0
+}
+
+func (d *debugger) step(args []string) error {
+	n := uint64(1)
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.ParseUint(args[0], 0, 64); err != nil {
+			return fmt.Errorf("invalid count %q", args[0])
+		}
+	}
+	d.interrupt.Store(false)
+	for i := uint64(0); i < n; i++ {
+		if d.stepOne() {
+			break
+		}
+		if i+1 < n && d.breaks[d.vm.PC] {
+			fmt.Fprintf(d.out, "breakpoint at 0x%06X\n", d.vm.PC)
+			break
+		}
+		if i+1 < n && d.interrupt.Swap(false) {
+			fmt.Fprintln(d.out, "interrupted")
+			break
+		}
+	}
+	d.where()
+	return nil
+}
+
+func (d *debugger) cont(args []string) error {
+	d.interrupt.Store(false)
+	for {
+		if d.stepOne() {
+			break
+		}
+		if d.breaks[d.vm.PC] {
+			fmt.Fprintf(d.out, "breakpoint at 0x%06X\n", d.vm.PC)
+			break
+		}
+		if d.interrupt.Swap(false) {
+			fmt.Fprintln(d.out, "interrupted")
+			break
+		}
+	}
+	d.where()
+	return nil
+}
+
+// ===================================================================
+// Breakpoints and Watchpoints
+// ===================================================================
+
+func (d *debugger) setBreak(args []string) error {
+	if len(args) == 0 {
+		addrs := make([]uint32, 0, len(d.breaks))
+		for pc := range d.breaks {
+			addrs = append(addrs, pc)
+		}
+		sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+		for _, pc := range addrs {
+			fmt.Fprintf(d.out, "breakpoint at 0x%06X%s\n", pc, d.symbolize(pc))
+		}
+		return nil
+	}
+	pc, err := d.codeAddr(args[0])
+	if err != nil {
+		return err
+	}
+	d.breaks[pc] = true
+	fmt.Fprintf(d.out, "breakpoint at 0x%06X%s\n", pc, d.symbolize(pc))
+	return nil
+}
+
+func (d *debugger) deleteBreak(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: delete addr")
+	}
+	pc, err := d.codeAddr(args[0])
+	if err != nil {
+		return err
+	}
+	if !d.breaks[pc] {
+		return fmt.Errorf("no breakpoint at 0x%06X", pc)
+	}
+	delete(d.breaks, pc)
+	return nil
+}
+
+func (d *debugger) watch(args []string) error {
+	if len(args) == 0 {
+		for _, w := range d.mem.watches {
+			fmt.Fprintf(d.out, "watchpoint 0x%08X, %d bytes\n", w.addr, w.size)
+		}
+		return nil
+	}
+	addr, err := d.dataAddr(args[0])
+	if err != nil {
+		return err
+	}
+	size := uint64(32)
+	if len(args) > 1 {
+		if size, err = strconv.ParseUint(args[1], 0, 32); err != nil || size == 0 {
+			return fmt.Errorf("invalid size %q", args[1])
+		}
+	}
+	d.mem.watches = append(d.mem.watches, watchpoint{addr, uint32(size)})
+	fmt.Fprintf(d.out, "watchpoint 0x%08X, %d bytes\n", addr, size)
+	return nil
+}
+
+func (d *debugger) unwatch(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: unwatch addr")
+	}
+	addr, err := d.dataAddr(args[0])
+	if err != nil {
+		return err
+	}
+	for i, w := range d.mem.watches {
+		if w.addr == addr {
+			d.mem.watches = append(d.mem.watches[:i], d.mem.watches[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no watchpoint at 0x%08X", addr)
+}
+
+// ===================================================================
+// Inspection
+// ===================================================================
+
+func (d *debugger) regs(args []string) error {
+	vm := d.vm
+	for i := range vm.R {
+		fmt.Fprintf(d.out, "R%d = %v (0x%X)\n", i, vm.Signed(i), vm.Unsigned(i))
+	}
+	for i := range vm.F {
+		fmt.Fprintf(d.out, "F%d = %s\n", i, vm.F[i].Text('g', 20))
+	}
+	for i := range vm.A {
+		fmt.Fprintf(d.out, "A%d = 0x%08X\n", i, vm.A[i])
+	}
+	var set []string
+	for _, f := range flagNames {
+		if vm.GetFlag(f.bit) {
+			set = append(set, f.name)
+		}
+	}
+	fmt.Fprintf(d.out, "SR = 0x%02X [%s]\n", vm.SR, strings.Join(set, " "))
+	fp := []string{vm.RoundingMode().String(), fmt.Sprintf("prec=%d", vm.Precision())}
+	for _, f := range fpFlagNames {
+		if vm.GetFPFlag(f.bit) {
+			fp = append(fp, f.name)
+		}
+	}
+	fmt.Fprintf(d.out, "FPCR = 0x%08X [%s]\n", vm.FPCR, strings.Join(fp, " "))
+	fmt.Fprintf(d.out, "PC = 0x%06X%s\n", vm.PC, d.symbolize(vm.PC))
+	fmt.Fprintf(d.out, "J  = 0x%06X%s\n", vm.J, d.symbolize(vm.J))
+	fmt.Fprintf(d.out, "SP = 0x%08X\n", vm.SP)
+	if vm.State == tmach.Halted {
+		fmt.Fprintf(d.out, "State = %v (exit code %d)\n", vm.State, vm.ExitCode)
+	} else {
+		fmt.Fprintf(d.out, "State = %v\n", vm.State)
+	}
+	return nil
+}
+
+func (d *debugger) list(args []string) error {
+	pc, n := d.vm.PC, uint64(11)
+	if len(args) > 0 {
+		var err error
+		if pc, err = d.codeAddr(args[0]); err != nil {
+			return err
+		}
+	}
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.ParseUint(args[1], 0, 16); err != nil || n == 0 {
+			return fmt.Errorf("invalid count %q", args[1])
+		}
+	}
+	start := pc - min(pc, uint32(n/2))
+	for addr := start; addr < start+uint32(n); addr++ {
+		if !d.line(addr) {
+			break
+		}
+	}
+	return nil
+}
+
+func (d *debugger) examine(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: x addr [n]")
+	}
+	addr, err := d.dataAddr(args[0])
+	if err != nil {
+		return err
+	}
+	n := uint64(32)
+	if len(args) > 1 {
+		if n, err = strconv.ParseUint(args[1], 0, 16); err != nil {
+			return fmt.Errorf("invalid count %q", args[1])
+		}
+	}
+	size := uint64(d.vm.Memory.Size())
+	if uint64(addr) >= size {
+		return fmt.Errorf("address 0x%08X is outside memory", addr)
+	}
+	buf := make([]byte, min(n, size-uint64(addr)))
+	d.mem.Memory.ReadBytes(addr, buf)
+	for i := 0; i < len(buf); i += 16 {
+		fmt.Fprintf(d.out, "%08X: % X\n", addr+uint32(i), buf[i:min(i+16, len(buf))])
+	}
+	return nil
+}
+
+func (d *debugger) help(args []string) error {
+	for _, c := range commands {
+		name := c.name
+		if c.alias != "" {
+			name += ", " + c.alias
+		}
+		fmt.Fprintf(d.out, "  %-20s %s\n", strings.TrimSpace(name+" "+c.args), c.help)
+	}
+	fmt.Fprintln(d.out, "Addresses are numbers or program symbols; an empty line repeats the last command.")
+	return nil
+}
+
+// where prints the instruction at PC.
+func (d *debugger) where() {
+	d.line(d.vm.PC)
+}
+
+// line prints a disassembly line for the instruction at pc, marking the
+// current instruction and breakpoints. It reports false if pc is outside
+// memory.
+func (d *debugger) line(pc uint32) bool {
+	addr := uint64(pc) * tmach.InstructionSize
+	if addr+tmach.InstructionSize > uint64(d.vm.Memory.Size()) {
+		return false
+	}
+	var b [tmach.InstructionSize]byte
+	d.mem.Memory.ReadBytes(uint32(addr), b[:])
+	word := binary.BigEndian.Uint32(b[:])
+	text, err := asm.Disassemble(word)
+	if err != nil {
+		text = fmt.Sprintf(".word 0x%08X", word)
+	}
+	mark := "  "
+	if pc == d.vm.PC {
+		mark = "=>"
+	}
+	bp := " "
+	if d.breaks[pc] {
+		bp = "*"
+	}
+	fmt.Fprintf(d.out, "%s%s %06X: %08X  %-24s%s%s\n", mark, bp, pc, word, text, d.symbolize(pc), d.source(pc))
+	return true
+}
+
+// ===================================================================
+// Symbols
+// ===================================================================
+
+// symbolize returns " <sym+off>" for the code symbol nearest below pc.
+func (d *debugger) symbolize(pc uint32) string {
+	var best *obj.Symbol
+	for i, s := range d.file.Symbols {
+		if s.Kind == obj.Code && s.Value <= pc && (best == nil || s.Value > best.Value) {
+			best = &d.file.Symbols[i]
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	if best.Value == pc {
+		return " <" + best.Name + ">"
+	}
+	return fmt.Sprintf(" <%s+%d>", best.Name, pc-best.Value)
+}
+
+// source returns " (file:line)" for the instruction at pc, if known.
+func (d *debugger) source(pc uint32) string {
+	if d.file.Debug == nil {
+		return ""
+	}
+	for _, l := range d.file.Debug.Lines {
+		if l.PC == pc {
+			return fmt.Sprintf(" (%s:%d)", d.file.Debug.Source, l.Line)
+		}
+	}
+	return ""
+}
+
+// codeAddr parses an instruction address or code symbol.
+func (d *debugger) codeAddr(s string) (uint32, error) {
+	if sym, ok := d.file.Lookup(s); ok {
+		if sym.Kind != obj.Code {
+			return 0, fmt.Errorf("%s is not a code symbol", s)
+		}
+		return sym.Value, nil
+	}
+	return parseAddr(s)
+}
+
+// dataAddr parses a byte address or symbol. Code symbols give the byte
+// address of their instruction.
+func (d *debugger) dataAddr(s string) (uint32, error) {
+	if sym, ok := d.file.Lookup(s); ok {
+		if sym.Kind == obj.Code {
+			return sym.Value * tmach.InstructionSize, nil
+		}
+		return sym.Value, nil
+	}
+	return parseAddr(s)
+}
+
+func parseAddr(s string) (uint32, error) {
+	v, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address or unknown symbol %q", s)
+	}
+	return uint32(v), nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xtaci/tmach"
+	"github.com/xtaci/tmach/asm"
+)
+
+// TestSession tests breakpoints, watchpoints and stepping in a scripted
+// session.
+func TestSession(t *testing.T) {
+	src := `
+	.entry start
+	.data
+total:	.u256 0
+	.text
+start:	LDI A0, total
+	LDI R1, 3
+	LDI R2, 1
+loop:	ADD R0, R0, R1
+	SUB R1, R1, R2
+	JNZ loop
+	STORE R0, [A0]
+done:	NOP
+	HALT 3
+`
+	prog, err := asm.Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := prog.Object("sum.s")
+	vm := tmach.NewVM()
+	if err := f.Load(vm); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	d := newDebugger(vm, f, &out)
+	script := "step 2\nbreak loop\ncontinue\n\nregs\ndelete loop\nwatch total\nc\nlist done 1\nx total 32\nstep 3\nregs\nquit\n"
+	if err := d.repl(strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+	if vm.PC != prog.Labels["done"]+1 || vm.R[0].Int64() != 6 {
+		t.Errorf("expected to halt after done with R0 = 6, got PC = %d, R0 = %v", vm.PC, vm.R[0])
+	}
+	for _, want := range []string{
+		"=>  000002: 18200001  LDI R2, 1                <start+2> (sum.s:8)",
+		"breakpoint at 0x000003 <loop>",
+		"R1 = 2 (0x2)",
+		"SR = 0x00 []",
+		"FPCR = 0x00ED0000 [ToNearestEven prec=237]",
+		"watchpoint 0x00000040: 32 bytes at 0x00000040",
+		"=>  000007: 00000000  NOP                      <done> (sum.s:13)",
+		"00000050: 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 06",
+		"program halted with exit code 3",
+		"State = halted (exit code 3)",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("session output lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+// interrupter is a hook that interrupts the debugger after n instructions,
+// as Ctrl-C would.
+type interrupter struct {
+	d *debugger
+	n int
+}
+
+func (h *interrupter) Before(vm *tmach.VM, in tmach.Inst) {}
+
+func (h *interrupter) After(vm *tmach.VM, in tmach.Inst, d *tmach.Delta) {
+	if h.n--; h.n == 0 {
+		h.d.interrupt.Store(true)
+	}
+}
+
+// TestInterrupt tests that an interrupt stops a long step.
+func TestInterrupt(t *testing.T) {
+	prog, err := asm.Assemble([]byte("loop:\tJMP loop\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := prog.Object("loop.s")
+	vm := tmach.NewVM()
+	if err := f.Load(vm); err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	d := newDebugger(vm, f, &out)
+	h := &interrupter{d: d, n: 100}
+	vm.Hook = h
+	if err := d.repl(strings.NewReader("step 100000000\nquit\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "interrupted") || h.n != 0 {
+		t.Errorf("expected step to stop after 100 instructions, stopped after %d:\n%s", 100-h.n, out.String())
+	}
+}
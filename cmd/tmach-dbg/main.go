@@ -0,0 +1,60 @@
+// Command tmach-dbg is an interactive debugger for tmach programs.
+//
+// Usage:
+//
+//	tmach-dbg program.tmo
+//
+// It loads an object file as tmach-run does and reads commands from standard
+// input. Type "help" for the list of commands. Addresses may be given as
+// numbers or as symbols of the program; an empty line repeats the previous
+// command.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/xtaci/tmach"
+	"github.com/xtaci/tmach/obj"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: tmach-dbg program.tmo\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := obj.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	vm := tmach.NewVM()
+	if err := f.Load(vm); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	d := newDebugger(vm, f, os.Stdout)
+
+	// Interrupt stops a running program instead of exiting the debugger.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		for range sig {
+			d.interrupt.Store(true)
+		}
+	}()
+
+	if err := d.repl(os.Stdin); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,84 @@
+// Command tmach-dis disassembles tmach machine code.
+//
+// Usage:
+//
+//	tmach-dis [-addr start] [-n count] input
+//
+// The input is an object file or raw big-endian 32-bit words, as produced by
+// tmach-asm and tmach-asm -raw. The listing is itself valid assembly,
+// annotated with each word's address and encoding, so it can be reassembled
+// or diffed against another program. Object files are listed whole, with
+// their symbols as labels; -addr and -n apply to raw input only.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/xtaci/tmach/asm"
+	"github.com/xtaci/tmach/obj"
+)
+
+func main() {
+	start := flag.Uint("addr", 0, "first instruction address to disassemble")
+	count := flag.Int("n", -1, "number of instructions to disassemble (default: to end of input)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: tmach-dis [-addr start] [-n count] input\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	if bytes.HasPrefix(data, []byte(obj.Magic)) {
+		f, err := obj.Read(bytes.NewReader(data))
+		if err == nil {
+			if err = asm.DumpObject(out, f); err == nil {
+				err = out.Flush()
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", flag.Arg(0), err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(data)%4 != 0 {
+		fmt.Fprintf(os.Stderr, "%s: size %d is not a multiple of 4\n", flag.Arg(0), len(data))
+		os.Exit(1)
+	}
+
+	words := make([]uint32, len(data)/4)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint32(data[i*4:])
+	}
+	if *start > uint(len(words)) {
+		fmt.Fprintf(os.Stderr, "start address 0x%X is past the end of input\n", *start)
+		os.Exit(1)
+	}
+	words = words[*start:]
+	if *count >= 0 && *count < len(words) {
+		words = words[:*count]
+	}
+
+	if err := asm.Dump(out, words, uint32(*start)); err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,106 @@
+// Command tmach-isa generates the instruction set reference from tmach.ISA.
+//
+// Usage:
+//
+//	tmach-isa [-o output]
+//
+// The reference is written as Markdown to output, or to standard output.
+// ISA.md at the root of the repository is generated with go generate and
+// checked by the tests of this command, so it always matches the table that
+// the VM and the assembler use.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/xtaci/tmach"
+)
+
+func main() {
+	output := flag.String("o", "", "output file (default: standard output)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: tmach-isa [-o output]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var file *os.File
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		file, w = f, f
+	}
+	out := bufio.NewWriter(w)
+	err := writeSpec(out)
+	if err == nil {
+		err = out.Flush()
+	}
+	// Standard output belongs to the caller; only the file we created is
+	// closed, and its Close reports write errors the file system delayed.
+	if file != nil {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+const header = `# tmach Instruction Set Reference
+
+<!-- Code generated by cmd/tmach-isa from tmach.ISA. DO NOT EDIT. -->
+
+Every instruction is one 32-bit word, stored big-endian, with the opcode in
+bits 24-31. Fields are listed from the most significant bit. Register fields
+hold R0-R7 as 0-7 and F0-F7 as 8-15; address register and ` + "`[Ax]`" + ` fields hold
+A0-A7 as 0-7. Reserved bits are ignored by the VM and must be zero for the
+disassembler. Gas is the cost in ` + "`tmach.DefaultCosts`" + `.
+
+| Opcode | Syntax | Encoding | Gas | Description |
+|--------|--------|----------|-----|-------------|
+`
+
+// writeSpec writes the reference table of tmach.ISA, one row per form, in
+// opcode order.
+func writeSpec(w io.Writer) error {
+	ops := append([]*tmach.Op(nil), tmach.ISA...)
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Opcode < ops[j].Opcode })
+
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		for i, f := range op.Forms {
+			summary := ""
+			if i == 0 {
+				summary = op.Summary
+			}
+			if _, err := fmt.Fprintf(w, "| `0x%02X` | `%s` | %s | %d | %s |\n", op.Opcode, f,
+				escape(f.Encoding()), tmach.DefaultCosts[op.Opcode], escape(summary)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// escape escapes the column separators in a table cell.
+func escape(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
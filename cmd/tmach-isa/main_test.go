@@ -0,0 +1,22 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// TestSpecUpToDate tests that ISA.md matches the table it is generated from.
+func TestSpecUpToDate(t *testing.T) {
+	want, err := os.ReadFile("../../ISA.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeSpec(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Error("ISA.md is out of date; run go generate")
+	}
+}
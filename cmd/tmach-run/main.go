@@ -0,0 +1,148 @@
+// Command tmach-run loads an object file into a virtual machine and runs it.
+//
+// Usage:
+//
+//	tmach-run [-regs] [-gas n] [-timeout d] [-trace file] [-trace-bin file] program.tmo
+//
+// The program runs from its entry point until it halts, by HALT or the exit
+// system call, and its exit code becomes the exit status of tmach-run. A
+// fault, including running off the end of memory, is reported on standard
+// error with exit status 1. With -regs, the registers are printed when the
+// program stops. -gas limits the program to n units of gas at the default
+// instruction costs; the gas left is printed with the registers. -timeout
+// stops the program after duration d, and an interrupt stops it at once;
+// either way the registers are still printed. -trace writes a text trace of
+// every executed instruction to file, or to standard error if file is "-";
+// -trace-bin writes a binary trace instead.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/xtaci/tmach"
+	"github.com/xtaci/tmach/obj"
+	"github.com/xtaci/tmach/trace"
+)
+
+func main() {
+	regs := flag.Bool("regs", false, "print the registers when the program stops")
+	gas := flag.Uint64("gas", 0, "run with a budget of `n` gas (0 for unlimited)")
+	timeout := flag.Duration("timeout", 0, "stop the program after `d` (0 for no limit)")
+	textTrace := flag.String("trace", "", "write a text trace to `file` (- for standard error)")
+	binTrace := flag.String("trace-bin", "", "write a binary trace to `file`")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: tmach-run [-regs] [-gas n] [-timeout d] [-trace file] [-trace-bin file] program.tmo\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := obj.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	vm := tmach.NewVM()
+	if err := f.Load(vm); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *gas > 0 {
+		vm.Costs, vm.Gas = &tmach.DefaultCosts, *gas
+	}
+
+	// main leaves by os.Exit, which skips deferred calls, so the trace is
+	// flushed and closed explicitly once the program stops.
+	var flush, closeTrace func() error
+	switch {
+	case *textTrace != "" && *binTrace != "":
+		fmt.Fprintln(os.Stderr, "tmach-run: -trace and -trace-bin are mutually exclusive")
+		os.Exit(2)
+	case *textTrace != "":
+		w, done := create(*textTrace)
+		closeTrace = done
+		tw := trace.NewTextWriter(w)
+		vm.Hook, flush = tw, tw.Flush
+	case *binTrace != "":
+		w, done := create(*binTrace)
+		closeTrace = done
+		bw := trace.NewBinaryWriter(w)
+		vm.Hook, flush = bw, bw.Flush
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	err = vm.RunContext(ctx)
+	traceFailed := false
+	if flush != nil {
+		ferr := flush()
+		if cerr := closeTrace(); ferr == nil {
+			ferr = cerr
+		}
+		if ferr != nil {
+			fmt.Fprintln(os.Stderr, ferr)
+			traceFailed = true
+		}
+	}
+	if *regs {
+		printRegisters(vm)
+	}
+	if err != nil && err == ctx.Err() {
+		fmt.Fprintf(os.Stderr, "tmach-run: stopped at PC 0x%06X: %v\n", vm.PC, err)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if traceFailed {
+		os.Exit(1)
+	}
+	os.Exit(vm.ExitCode)
+}
+
+// create opens the named trace file, or standard error for "-". The returned
+// function closes the file, reporting write errors the file system delayed;
+// standard error is left open.
+func create(name string) (io.Writer, func() error) {
+	if name == "-" {
+		return os.Stderr, func() error { return nil }
+	}
+	fd, err := os.Create(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return fd, fd.Close
+}
+
+func printRegisters(vm *tmach.VM) {
+	for i := range vm.R {
+		fmt.Printf("R%d = %v\n", i, vm.R[i])
+	}
+	for i := range vm.F {
+		fmt.Printf("F%d = %v\n", i, vm.F[i].Text('g', 20))
+	}
+	for i := range vm.A {
+		fmt.Printf("A%d = 0x%08X\n", i, vm.A[i])
+	}
+	fmt.Printf("SR = 0x%02X  FPCR = 0x%08X  PC = 0x%06X  J = 0x%06X  SP = 0x%08X\n", vm.SR, vm.FPCR, vm.PC, vm.J, vm.SP)
+	fmt.Printf("State = %v  ExitCode = %d\n", vm.State, vm.ExitCode)
+	if vm.Costs != nil {
+		fmt.Printf("Gas = %d\n", vm.Gas)
+	}
+}
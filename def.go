@@ -1,6 +1,6 @@
 package tmach
 
-// Instruction Opcodes
+// Instruction Opcodes. The forms and encoding of each are given by ISA.
 const (
 	OP_NOP   = 0x00 // No operation
 	OP_LOAD  = 0x08 // LOAD Rd, [Ax]
@@ -26,6 +26,83 @@ const (
 	OP_JGT   = 0x15 // JGT Addr
 	OP_JLT   = 0x16 // JLT Addr
 	OP_JEQ   = 0x17 // JEQ Addr
+	OP_LDI   = 0x18 // LDI Rd, Imm20
+	OP_LDW   = 0x19 // LDW Rd, N (followed by N literal words)
+	OP_MOV   = 0x1A // MOV Rd, Rs
+	OP_RTOA  = 0x1B // MOV Ad, Rs
+	OP_ATOR  = 0x1C // MOV Rd, As
+	OP_MOVA  = 0x1D // MOV Ad, As
+	OP_LDA   = 0x1E // LDI Ad, Imm20
+	OP_ADDA  = 0x1F // ADD Ad, As, Simm16
+	OP_ADDAR = 0x20 // ADD Ad, As, Rt
+	OP_PUSH  = 0x21 // PUSH Rs
+	OP_POP   = 0x22 // POP Rd
+	OP_PUSHA = 0x23 // PUSH As
+	OP_POPA  = 0x24 // POP Ad
+	OP_CALL  = 0x25 // CALL Addr
+	OP_RET   = 0x26 // RET
+	OP_GETSP = 0x27 // MOV Ad, SP
+	OP_SETSP = 0x28 // MOV SP, As
+	OP_SDIV  = 0x29 // SDIV Rd, Rs, Rt
+	OP_SMOD  = 0x2A // SMOD Rd, Rs, Rt
+	OP_SCMP  = 0x2B // SCMP Rs, Rt
+	OP_SAR   = 0x2C // SAR Rd, Rs, N
+
+	OP_SYSCALL = 0x2D // SYSCALL Imm16
+	OP_HALT    = 0x2E // HALT Imm8
+	OP_JNE     = 0x2F // JNE Addr
+	OP_JGE     = 0x30 // JGE Addr
+	OP_JLE     = 0x31 // JLE Addr
+	OP_JMPA    = 0x32 // JMP As
+	OP_JMPJ    = 0x33 // JMP J
+	OP_BRA     = 0x34 // BRA Off24
+	OP_BZ      = 0x35 // BZ Off24
+	OP_BNZ     = 0x36 // BNZ Off24
+	OP_BEQ     = 0x37 // BEQ Off24
+	OP_BNE     = 0x38 // BNE Off24
+	OP_BGT     = 0x39 // BGT Off24
+	OP_BLT     = 0x3A // BLT Off24
+	OP_BGE     = 0x3B // BGE Off24
+	OP_BLE     = 0x3C // BLE Off24
+	OP_SQRT    = 0x3D // SQRT Fd, Fs
+	OP_ABS     = 0x3E // ABS Fd, Fs
+	OP_NEG     = 0x3F // NEG Fd, Fs
+	OP_FLOOR   = 0x40 // FLOOR Fd, Fs
+	OP_CEIL    = 0x41 // CEIL Fd, Fs
+	OP_ROUND   = 0x42 // ROUND Fd, Fs
+	OP_TRUNC   = 0x43 // TRUNC Fd, Fs
+	OP_EXP     = 0x44 // EXP Fd, Fs
+	OP_LOG     = 0x45 // LOG Fd, Fs
+	OP_SIN     = 0x46 // SIN Fd, Fs
+	OP_COS     = 0x47 // COS Fd, Fs
+	OP_ATAN    = 0x48 // ATAN Fd, Fs
+	OP_POW     = 0x49 // POW Fd, Fs, Ft
+	OP_GETFPCR = 0x4A // MOV Rd, FPCR
+	OP_SETFPCR = 0x4B // MOV FPCR, Rs
+	OP_ADDMOD  = 0x4C // ADDMOD Rd, Rs, Rt, Rm
+	OP_SUBMOD  = 0x4D // SUBMOD Rd, Rs, Rt, Rm
+	OP_MULMOD  = 0x4E // MULMOD Rd, Rs, Rt, Rm
+	OP_EXPMOD  = 0x4F // EXPMOD Rd, Rs, Rt, Rm
+	OP_INVMOD  = 0x50 // INVMOD Rd, Rs, Rt
+	OP_POPCNT  = 0x51 // POPCNT Rd, Rs
+	OP_CLZ     = 0x52 // CLZ Rd, Rs
+	OP_CTZ     = 0x53 // CTZ Rd, Rs
+	OP_BSWAP   = 0x54 // BSWAP Rd, Rs
+	OP_BT      = 0x55 // BT Rs, Rt
+	OP_BTS     = 0x56 // BTS Rd, Rs, Rt
+	OP_BTR     = 0x57 // BTR Rd, Rs, Rt
+	OP_BTC     = 0x58 // BTC Rd, Rs, Rt
+	OP_BTI     = 0x59 // BT Rs, Imm8
+	OP_BTSI    = 0x5A // BTS Rd, Rs, Imm8
+	OP_BTRI    = 0x5B // BTR Rd, Rs, Imm8
+	OP_BTCI    = 0x5C // BTC Rd, Rs, Imm8
+	OP_LSHV    = 0x5D // LSH Rd, Rs, Rt
+	OP_RSHV    = 0x5E // RSH Rd, Rs, Rt
+	OP_SARV    = 0x5F // SAR Rd, Rs, Rt
+	OP_ROL     = 0x60 // ROL Rd, Rs, Imm8
+	OP_ROR     = 0x61 // ROR Rd, Rs, Imm8
+	OP_ROLV    = 0x62 // ROL Rd, Rs, Rt
+	OP_RORV    = 0x63 // ROR Rd, Rs, Rt
 )
 
 // Status Register Flags
@@ -35,4 +112,15 @@ const (
 	DF = 2 // Divide-by-Zero Flag
 	LT = 3 // Less Than Flag
 	GT = 4 // Greater Than Flag
+	EQ = 5 // Equal Flag
+	CF = 6 // Carry Flag
+)
+
+// Floating-Point Control Register Fields
+const (
+	RM     = 0  // Bits 0-2: Rounding Mode, numbered as big.RoundingMode
+	IX     = 3  // Inexact Flag
+	UF     = 4  // Underflow Flag
+	OV     = 5  // Overflow Flag
+	FPPrec = 16 // Bits 16-31: Precision in bits
 )
@@ -0,0 +1,66 @@
+package tmach
+
+import "fmt"
+
+// FaultKind classifies a machine fault.
+type FaultKind int
+
+const (
+	FaultMemory         FaultKind = iota + 1 // Memory access out of bounds
+	FaultRegister                            // Invalid register operand
+	FaultOpcode                              // Unknown opcode
+	FaultFloat                               // Invalid floating-point operation (NaN result) or FPCR value
+	FaultStackOverflow                       // Push below the stack limit
+	FaultStackUnderflow                      // Pop above the stack base
+	FaultOutOfGas                            // Gas budget exhausted
+	FaultSyscall                             // Unregistered system call
+	FaultArithmetic                          // Integer operation without a result, e.g. no modular inverse
+)
+
+func (k FaultKind) String() string {
+	switch k {
+	case FaultMemory:
+		return "memory access out of bounds"
+	case FaultRegister:
+		return "invalid register"
+	case FaultOpcode:
+		return "unknown opcode"
+	case FaultFloat:
+		return "invalid floating-point operation"
+	case FaultStackOverflow:
+		return "stack overflow"
+	case FaultStackUnderflow:
+		return "stack underflow"
+	case FaultOutOfGas:
+		return "out of gas"
+	case FaultSyscall:
+		return "unknown system call"
+	case FaultArithmetic:
+		return "arithmetic error"
+	}
+	return fmt.Sprintf("fault %d", int(k))
+}
+
+// Fault is raised when an instruction cannot be executed. The instruction
+// has no effect on the machine state, and PC still refers to it.
+type Fault struct {
+	Kind        FaultKind
+	PC          uint32 // Address of the faulting instruction
+	Instruction uint32 // The faulting instruction word
+	Addr        uint32 // Offending memory address, for FaultMemory; SP, for stack faults
+}
+
+func (f *Fault) Error() string {
+	if f.Kind == FaultMemory {
+		return fmt.Sprintf("tmach: %v at address 0x%X (PC 0x%X, instruction 0x%08X)", f.Kind, f.Addr, f.PC, f.Instruction)
+	}
+	return fmt.Sprintf("tmach: %v (PC 0x%X, instruction 0x%08X)", f.Kind, f.PC, f.Instruction)
+}
+
+// FaultHandler is called by Step when an instruction faults. If it returns
+// nil the fault is considered handled and execution continues at PC, which
+// the handler may have changed: setting PC to PC+1 skips the instruction,
+// and calling vm.Jump(vector) transfers control to a guest trap handler
+// with the address of the next instruction saved in J. A non-nil error is
+// returned from Step.
+type FaultHandler func(vm *VM, f *Fault) error
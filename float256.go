@@ -0,0 +1,149 @@
+package tmach
+
+import (
+	"errors"
+	"math/big"
+)
+
+// Floating-point registers hold IEEE 754 binary256 (octuple precision)
+// values: 1 sign bit, 19 exponent bits and a 237-bit significand with an
+// implicit leading bit. This is exactly the 256-bit format F registers are
+// stored in memory, so LOAD and STORE round-trip every value bit for bit.
+
+// FloatPrec is the precision in bits of the F registers.
+const FloatPrec = 237
+
+const (
+	float256FracBits = FloatPrec - 1    // explicitly stored significand bits
+	float256ExpMask  = 1<<19 - 1        // biased exponent of Inf and NaN
+	float256Bias     = 1<<18 - 1        // exponent bias
+	float256EMax     = float256Bias     // largest normal exponent
+	float256EMin     = 1 - float256Bias // smallest normal exponent
+)
+
+// float256FracMask selects the stored significand bits.
+var float256FracMask = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), float256FracBits), big.NewInt(1))
+
+// ErrNaN is returned by DecodeFloat256 for NaN encodings, which big.Float
+// cannot represent.
+var ErrNaN = errors.New("tmach: NaN is not representable")
+
+// EncodeFloat256 returns x in IEEE 754 binary256 format, big-endian.
+// x is rounded to nearest even if it has more than FloatPrec bits of
+// precision; values beyond the exponent range become infinities, subnormals
+// or zeros as IEEE 754 specifies.
+func EncodeFloat256(x *big.Float) [32]byte {
+	return encodeFloat256(x, big.ToNearestEven)
+}
+
+// encodeFloat256 encodes x rounding according to mode.
+func encodeFloat256(x *big.Float, mode big.RoundingMode) [32]byte {
+	neg := x.Signbit()
+	bits := new(big.Int)
+	switch {
+	case x.IsInf():
+		bits.Lsh(big.NewInt(float256ExpMask), float256FracBits)
+	case x.Sign() != 0:
+		mode = absMode(mode, neg)
+
+		// abs = 1.f × 2^e; subnormals share the exponent of the smallest normal.
+		abs := new(big.Float).Abs(x)
+		e := max(abs.MantExp(nil)-1, float256EMin)
+		m := roundInt(new(big.Float).SetMantExp(abs, float256FracBits-e), mode)
+		if m.BitLen() > FloatPrec {
+			// Rounding carried into a new leading bit; m is a power of two.
+			m.Rsh(m, 1)
+			e++
+		}
+
+		switch {
+		case e > float256EMax:
+			if mode == big.ToZero {
+				// Largest finite value.
+				bits.Lsh(big.NewInt(float256ExpMask-1), float256FracBits)
+				bits.Or(bits, float256FracMask)
+			} else {
+				bits.Lsh(big.NewInt(float256ExpMask), float256FracBits)
+			}
+		case m.BitLen() == FloatPrec:
+			// Normal: replace the implicit leading bit by the biased exponent.
+			m.SetBit(m, float256FracBits, 0)
+			bits.Lsh(big.NewInt(int64(e+float256Bias)), float256FracBits)
+			bits.Or(bits, m)
+		default:
+			// Subnormal or zero: biased exponent 0.
+			bits.Set(m)
+		}
+	}
+	if neg {
+		bits.SetBit(bits, 255, 1)
+	}
+
+	var b [32]byte
+	bits.FillBytes(b[:])
+	return b
+}
+
+// absMode returns the rounding of the magnitude of a value of the given sign
+// that rounding the value in mode amounts to.
+func absMode(mode big.RoundingMode, neg bool) big.RoundingMode {
+	switch {
+	case mode == big.ToPositiveInf && neg, mode == big.ToNegativeInf && !neg:
+		return big.ToZero
+	case mode == big.ToPositiveInf, mode == big.ToNegativeInf:
+		return big.AwayFromZero
+	}
+	return mode
+}
+
+// roundInt rounds the non-negative x to an integer using mode, which must
+// be ToZero, AwayFromZero, ToNearestEven or ToNearestAway.
+func roundInt(x *big.Float, mode big.RoundingMode) *big.Int {
+	i, acc := x.Int(nil)
+	if acc == big.Exact {
+		return i
+	}
+	switch mode {
+	case big.AwayFromZero:
+		i.Add(i, big.NewInt(1))
+	case big.ToNearestEven, big.ToNearestAway:
+		rem := new(big.Float).Sub(x, new(big.Float).SetInt(i))
+		c := rem.Cmp(big.NewFloat(0.5))
+		if c > 0 || c == 0 && (mode == big.ToNearestAway || i.Bit(0) == 1) {
+			i.Add(i, big.NewInt(1))
+		}
+	}
+	return i
+}
+
+// DecodeFloat256 decodes a big-endian IEEE 754 binary256 value into a
+// big.Float of precision FloatPrec. The result is exact. It returns ErrNaN
+// for NaN encodings.
+func DecodeFloat256(b []byte) (*big.Float, error) {
+	bits := new(big.Int).SetBytes(b[:32])
+	neg := bits.Bit(255) == 1
+	biased := int(new(big.Int).Rsh(bits, float256FracBits).Uint64() & float256ExpMask)
+	frac := new(big.Int).And(bits, float256FracMask)
+
+	z := new(big.Float).SetPrec(FloatPrec)
+	switch biased {
+	case float256ExpMask:
+		if frac.Sign() != 0 {
+			return nil, ErrNaN
+		}
+		z.SetInf(neg)
+		return z, nil
+	case 0:
+		// Subnormal: frac × 2^(EMin-236).
+		z.SetInt(frac)
+		z.SetMantExp(z, float256EMin-float256FracBits)
+	default:
+		frac.SetBit(frac, float256FracBits, 1)
+		z.SetInt(frac)
+		z.SetMantExp(z, biased-float256Bias-float256FracBits)
+	}
+	if neg {
+		z.Neg(z)
+	}
+	return z, nil
+}
@@ -0,0 +1,111 @@
+package tmach
+
+import (
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// TestFloat256Encoding tests known binary256 encodings.
+func TestFloat256Encoding(t *testing.T) {
+	tests := []struct {
+		x   *big.Float
+		hex string // leading bytes; the rest are zero
+	}{
+		{big.NewFloat(1), "3ffff0"},
+		{big.NewFloat(2), "400000"},
+		{big.NewFloat(-2), "c00000"},
+		{big.NewFloat(0.5), "3fffe0"},
+		{new(big.Float), "00"},
+		{new(big.Float).Neg(new(big.Float)), "80"},
+		{new(big.Float).SetInf(false), "7ffff0"},
+		{new(big.Float).SetInf(true), "fffff0"},
+		// Smallest subnormal, 2^-262378.
+		{new(big.Float).SetMantExp(big.NewFloat(1), -262378), strings.Repeat("00", 31) + "01"},
+	}
+	for _, tt := range tests {
+		b := EncodeFloat256(tt.x)
+		expected := tt.hex + strings.Repeat("0", 64-len(tt.hex))
+		if got := hex.EncodeToString(b[:]); got != expected {
+			t.Errorf("%v: expected %s, got %s", tt.x, expected, got)
+		}
+	}
+}
+
+// TestFloat256RoundTrip tests that values survive encoding exactly.
+func TestFloat256RoundTrip(t *testing.T) {
+	third := new(big.Float).SetPrec(FloatPrec).Quo(big.NewFloat(1), big.NewFloat(3))
+	tiny := new(big.Float).SetMantExp(big.NewFloat(3), -262378) // subnormal
+	huge := new(big.Float).SetPrec(FloatPrec).SetMantExp(third, 262144)
+	for _, x := range []*big.Float{third, new(big.Float).Neg(third), tiny, huge, big.NewFloat(123.456)} {
+		b := EncodeFloat256(x)
+		y, err := DecodeFloat256(b[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if y.Cmp(x) != 0 || y.Signbit() != x.Signbit() {
+			t.Errorf("round trip of %g gave %g", x, y)
+		}
+	}
+
+	nan := EncodeFloat256(new(big.Float).SetInf(false))
+	nan[31] = 1
+	if _, err := DecodeFloat256(nan[:]); err != ErrNaN {
+		t.Errorf("expected ErrNaN, got %v", err)
+	}
+}
+
+// TestFloat256Rounding tests rounding to the binary256 precision and range.
+func TestFloat256Rounding(t *testing.T) {
+	one := big.NewFloat(1)
+	ulp := new(big.Float).SetMantExp(one, -236)
+
+	// 1 + ulp/2 is a tie and rounds to even, 1.
+	x := new(big.Float).SetPrec(300).Add(one, new(big.Float).SetMantExp(one, -237))
+	b := EncodeFloat256(x)
+	if y, _ := DecodeFloat256(b[:]); y.Cmp(one) != 0 {
+		t.Errorf("tie: expected 1, got %g", y)
+	}
+
+	// 1 + 3ulp/4 rounds up to 1 + ulp.
+	x.Add(one, new(big.Float).SetMantExp(big.NewFloat(3), -238))
+	b = EncodeFloat256(x)
+	if y, _ := DecodeFloat256(b[:]); y.Cmp(new(big.Float).SetPrec(300).Add(one, ulp)) != 0 {
+		t.Errorf("expected 1 + ulp, got %g", y)
+	}
+
+	// 2^262144 overflows to +Inf.
+	b = EncodeFloat256(new(big.Float).SetMantExp(one, 262144))
+	if y, _ := DecodeFloat256(b[:]); !y.IsInf() {
+		t.Errorf("expected Inf, got %g", y)
+	}
+}
+
+// TestFloatLoadStore tests that F registers round-trip through memory exactly.
+func TestFloatLoadStore(t *testing.T) {
+	vm := NewVM()
+
+	vm.F[1].SetInt64(1)
+	vm.F[2].SetInt64(3)
+	vm.Div(8, 9, 10) // F0 = 1/3
+
+	vm.A[0] = 0x1000
+	vm.Store(8, 0) // STORE F0, [A0]
+	vm.Load(11, 0) // LOAD F3, [A0]
+	if vm.F[3].Cmp(vm.F[0]) != 0 {
+		t.Errorf("LOAD/STORE failed: expected %g, got %g", vm.F[0], vm.F[3])
+	}
+
+	vm.Push(8) // PUSH F0
+	vm.Pop(12) // POP F4
+	if vm.F[4].Cmp(vm.F[0]) != 0 {
+		t.Errorf("PUSH/POP failed: expected %g, got %g", vm.F[0], vm.F[4])
+	}
+
+	// A NaN in memory cannot be loaded.
+	vm.Memory.WriteBytes(0x1000, []byte{0x7F, 0xFF, 0xF8})
+	if f, ok := vm.Load(11, 0).(*Fault); !ok || f.Kind != FaultFloat {
+		t.Errorf("expected float fault, got %v", f)
+	}
+}
@@ -0,0 +1,501 @@
+package tmach
+
+import (
+	"math"
+	"math/big"
+	"math/bits"
+	"sync"
+)
+
+// ===================================================================
+// Floating-Point Functions
+// ===================================================================
+//
+// The functions below compute to the working precision they are passed,
+// carrying guardBits more internally, and fround rounds their result to the
+// FPCR precision and rounding mode. The result is within one unit in the
+// last place of the exact value; exact cases such as EXP 0 and integral
+// powers of small integers are exact.
+
+// guardBits is the extra precision carried by the series evaluations.
+const guardBits = 64
+
+// maxTrigExp bounds the binary exponent of SIN and COS arguments. Reducing
+// an argument modulo π/2 needs π to about as many bits as the exponent.
+const maxTrigExp = 1 << 12
+
+// zivLimit bounds the extra precision fround tries before it takes a result
+// that stays on a rounding boundary to be exact.
+const zivLimit = 512
+
+// newFloat returns a zero Float of precision prec.
+func newFloat(prec uint) *big.Float {
+	return new(big.Float).SetPrec(prec)
+}
+
+// exponent returns the binary exponent of a finite non-zero x, such that
+// 2^(e-1) <= |x| < 2^e.
+func exponent(x *big.Float) int {
+	return x.MantExp(nil)
+}
+
+// fround returns the value of f rounded to prec bits in mode. f(w) returns
+// its result to w bits, within one unit in the last place of the exact
+// value, or nil. Following Ziv, w grows until both ends of that error bound
+// round to the same value, so the result is correctly rounded in every
+// mode. Infinite and zero results, whose accuracy reports whether they are
+// exact, are returned as they are.
+func fround(f func(w uint) *big.Float, prec uint, mode big.RoundingMode) *big.Float {
+	for extra := uint(guardBits); ; extra *= 2 {
+		w := prec + extra
+		y := f(w)
+		if y == nil || y.IsInf() || y.Sign() == 0 {
+			return y
+		}
+		z := newFloat(prec).SetMode(mode).Set(y)
+		if extra >= zivLimit {
+			return z
+		}
+		ulp := new(big.Float).SetMantExp(big.NewFloat(1), exponent(y)-int(w))
+		lo := newFloat(prec).SetMode(mode).Set(newFloat(w+1).Sub(y, ulp))
+		hi := newFloat(prec).SetMode(mode).Set(newFloat(w+1).Add(y, ulp))
+		if lo.Cmp(hi) == 0 {
+			return z
+		}
+	}
+}
+
+// outOfRange returns the result of an operation on finite operands that
+// overflows the exponent range of big.Float, an infinity, or underflows it,
+// a zero, with an accuracy that reports it inexact.
+func outOfRange(inf, neg bool) *big.Float {
+	mant := big.NewFloat(0.25) // 0.5 × 2^-1
+	exp := big.MinExp
+	if inf {
+		mant, exp = big.NewFloat(1), big.MaxExp // 0.5 × 2^1
+	}
+	if neg {
+		mant.Neg(mant)
+	}
+	return new(big.Float).SetMantExp(mant, exp)
+}
+
+// constant caches a mathematical constant at the highest precision computed
+// so far.
+type constant struct {
+	mu      sync.Mutex
+	v       *big.Float
+	compute func(prec uint) *big.Float
+}
+
+// get returns the constant rounded to prec bits.
+func (c *constant) get(prec uint) *big.Float {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.v == nil || c.v.Prec() < prec {
+		c.v = c.compute(prec)
+	}
+	return newFloat(prec).Set(c.v)
+}
+
+var (
+	constPi  = &constant{compute: computePi}
+	constLn2 = &constant{compute: computeLn2}
+)
+
+// computePi computes π by Machin's formula, π = 16 atan(1/5) - 4 atan(1/239).
+func computePi(prec uint) *big.Float {
+	w := prec + guardBits
+	pi := atanInv(5, w)
+	pi.SetMantExp(pi, 4)
+	b := atanInv(239, w)
+	b.SetMantExp(b, 2)
+	return newFloat(prec).Sub(pi, b)
+}
+
+// computeLn2 computes ln 2 = 2 atanh(1/3).
+func computeLn2(prec uint) *big.Float {
+	w := prec + guardBits
+	x := newFloat(w).Quo(big.NewFloat(1), big.NewFloat(3))
+	s := atanhSeries(x, w)
+	return newFloat(prec).SetMantExp(s, 1)
+}
+
+// atanInv returns atan(1/n) = Σ (-1)^k / ((2k+1) n^(2k+1)) to prec bits.
+func atanInv(n int64, prec uint) *big.Float {
+	x := newFloat(prec).Quo(big.NewFloat(1), big.NewFloat(float64(n)))
+	return atanSeries(x, prec)
+}
+
+// atanSeries returns atan x = x - x³/3 + x⁵/5 - ... for |x| < 1 to prec bits.
+func atanSeries(x *big.Float, prec uint) *big.Float {
+	return oddSeries(x, prec, true)
+}
+
+// atanhSeries returns atanh x = x + x³/3 + x⁵/5 + ... for |x| < 1 to prec bits.
+func atanhSeries(x *big.Float, prec uint) *big.Float {
+	return oddSeries(x, prec, false)
+}
+
+// oddSeries sums x^(2k+1)/(2k+1), alternating signs if alternate is set,
+// until the terms fall below 2^-prec relative to the sum.
+func oddSeries(x *big.Float, prec uint, alternate bool) *big.Float {
+	x2 := newFloat(prec).Mul(x, x)
+	if alternate {
+		x2.Neg(x2)
+	}
+	power := newFloat(prec).Set(x)
+	sum := newFloat(prec).Set(x)
+	term := newFloat(prec)
+	for k := int64(3); ; k += 2 {
+		power.Mul(power, x2)
+		term.Quo(power, big.NewFloat(float64(k)))
+		if term.Sign() == 0 || exponent(term) < exponent(sum)-int(prec)-1 {
+			return sum
+		}
+		sum.Add(sum, term)
+	}
+}
+
+// expSeries returns e^x = 1 + x + x²/2! + ... for small |x| to prec bits.
+func expSeries(x *big.Float, prec uint) *big.Float {
+	sum := newFloat(prec).SetInt64(1)
+	term := newFloat(prec).SetInt64(1)
+	for k := int64(1); ; k++ {
+		term.Mul(term, x)
+		term.Quo(term, big.NewFloat(float64(k)))
+		if term.Sign() == 0 || exponent(term) < -int(prec)-1 {
+			return sum
+		}
+		sum.Add(sum, term)
+	}
+}
+
+// sinSeries returns sin x = x - x³/3! + ... and cosSeries returns
+// cos x = 1 - x²/2! + ..., for |x| <= π/4, to prec bits.
+func sinSeries(x *big.Float, prec uint) *big.Float {
+	return trigSeries(newFloat(prec).Set(x), x, 2, prec)
+}
+
+func cosSeries(x *big.Float, prec uint) *big.Float {
+	return trigSeries(newFloat(prec).SetInt64(1), x, 1, prec)
+}
+
+// trigSeries sums first - first·x²/(k(k+1)) + ..., with k starting at k0.
+func trigSeries(first, x *big.Float, k0 int64, prec uint) *big.Float {
+	x2 := newFloat(prec).Mul(x, x)
+	x2.Neg(x2)
+	sum := newFloat(prec).Set(first)
+	term := newFloat(prec).Set(first)
+	for k := k0; ; k += 2 {
+		term.Mul(term, x2)
+		term.Quo(term, big.NewFloat(float64(k*(k+1))))
+		if term.Sign() == 0 || exponent(term) < exponent(sum)-int(prec)-1 {
+			return sum
+		}
+		sum.Add(sum, term)
+	}
+}
+
+// workPrec returns the working precision for a result of prec bits computed
+// from x, with extra further bits.
+func workPrec(prec uint, x *big.Float, extra uint) uint {
+	return max(prec, x.Prec()) + guardBits + extra
+}
+
+// fexp returns e^x to prec bits.
+func fexp(x *big.Float, prec uint) *big.Float {
+	switch {
+	case x.IsInf():
+		if x.Sign() > 0 {
+			return newFloat(prec).SetInf(false)
+		}
+		return newFloat(prec)
+	case x.Sign() == 0:
+		return newFloat(prec).SetInt64(1)
+	}
+	// e^x overflows or underflows the exponent range of big.Float beyond
+	// about ±2^31 ln 2.
+	f, _ := x.Float64()
+	if f > (big.MaxExp+1)*math.Ln2 {
+		return outOfRange(true, false)
+	} else if f < (big.MinExp-float64(prec)-1)*math.Ln2 {
+		return outOfRange(false, false)
+	}
+
+	// x = k ln 2 + r with |r| <= ln 2 / 2, so e^x = 2^k e^r. Halving r
+	// squareCount times speeds up the series, and squaring the result as
+	// often restores it.
+	const squareCount = 16
+	k := int64(math.Round(f / math.Ln2))
+	w := workPrec(prec, x, uint(bits.Len64(uint64(abs64(k))))+squareCount)
+	r := newFloat(w).Mul(constLn2.get(w), newFloat(w).SetInt64(k))
+	r.Sub(x, r)
+	r.SetMantExp(r, -squareCount)
+	y := expSeries(r, w)
+	for i := 0; i < squareCount; i++ {
+		y.Mul(y, y)
+	}
+	return newFloat(prec).SetMantExp(y, int(k))
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// flog returns the natural logarithm of x to prec bits, or nil if x is
+// negative.
+func flog(x *big.Float, prec uint) *big.Float {
+	switch {
+	case x.Sign() < 0:
+		return nil
+	case x.Sign() == 0:
+		return newFloat(prec).SetInf(true)
+	case x.IsInf():
+		return newFloat(prec).SetInf(false)
+	}
+
+	// x = m 2^e with √½ <= m < √2, so that ln x = ln m + e ln 2 does not
+	// cancel when x is close to 1.
+	w := workPrec(prec, x, 0)
+	m := newFloat(w)
+	e := x.MantExp(m)
+	if m.Cmp(big.NewFloat(math.Sqrt2/2)) < 0 {
+		m.SetMantExp(m, 1)
+		e--
+	}
+	if e == 0 && m.Cmp(big.NewFloat(1)) == 0 {
+		return newFloat(prec)
+	}
+	w += uint(bits.Len(uint(max(e, -e))))
+
+	// ln m = 2 atanh((m-1)/(m+1)).
+	one := big.NewFloat(1)
+	z := newFloat(w).Quo(newFloat(w).Sub(m, one), newFloat(w).Add(m, one))
+	y := atanhSeries(z, w)
+	y.SetMantExp(y, 1)
+	if e != 0 {
+		y.Add(y, newFloat(w).Mul(constLn2.get(w), newFloat(w).SetInt64(int64(e))))
+	}
+	return newFloat(prec).Set(y)
+}
+
+// fsincos returns sin x, or cos x if cos is set, to prec bits. It returns
+// nil for infinite arguments and arguments beyond 2^maxTrigExp.
+func fsincos(x *big.Float, prec uint, cos bool) *big.Float {
+	switch {
+	case x.IsInf():
+		return nil
+	case x.Sign() == 0:
+		if cos {
+			return newFloat(prec).SetInt64(1)
+		}
+		return newFloat(prec).Set(x)
+	}
+	xexp := max(exponent(x), 0)
+	if xexp > maxTrigExp {
+		return nil
+	}
+
+	// x = k π/2 + r with |r| <= π/4. Rounding π/2 to w bits leaves an
+	// error of about |k| 2^-w in r, so w grows until r keeps guardBits/2
+	// more bits than the result needs.
+	for extra := uint(guardBits); ; extra *= 2 {
+		w := workPrec(prec, x, uint(xexp)+extra-guardBits)
+		halfPi := constPi.get(w)
+		halfPi.SetMantExp(halfPi, -1)
+		k, _ := froundInt(newFloat(w).Quo(x, halfPi), roundHalfAway).Int(nil)
+		r := newFloat(w).Mul(halfPi, newFloat(w).SetInt(k))
+		r.Sub(x, r)
+		if k.Sign() != 0 && (r.Sign() == 0 || int(extra)+exponent(r) < guardBits/2) {
+			continue
+		}
+
+		var y *big.Float
+		switch quadrant := new(big.Int).And(k, big.NewInt(3)).Int64(); {
+		case !cos && quadrant == 0, cos && quadrant == 3:
+			y = sinSeries(r, w)
+		case !cos && quadrant == 1, cos && quadrant == 0:
+			y = cosSeries(r, w)
+		case !cos && quadrant == 2, cos && quadrant == 1:
+			y = sinSeries(r, w)
+			y.Neg(y)
+		default:
+			y = cosSeries(r, w)
+			y.Neg(y)
+		}
+		return newFloat(prec).Set(y)
+	}
+}
+
+// fatan returns the arctangent of x to prec bits.
+func fatan(x *big.Float, prec uint) *big.Float {
+	if x.Sign() == 0 {
+		return newFloat(prec).Set(x)
+	}
+	w := workPrec(prec, x, 0)
+	halfPi := constPi.get(w)
+	halfPi.SetMantExp(halfPi, -1)
+	var y *big.Float
+	if x.IsInf() {
+		y = halfPi
+	} else {
+		// For |x| > 1, atan |x| = π/2 - atan(1/|x|). The argument is then
+		// halved with atan a = 2 atan(a / (1 + √(1 + a²))) until the series
+		// converges quickly.
+		a := newFloat(w).Abs(x)
+		invert := a.Cmp(big.NewFloat(1)) > 0
+		if invert {
+			a.Quo(big.NewFloat(1), a)
+		}
+		halvings := 0
+		one := big.NewFloat(1)
+		for a.Cmp(big.NewFloat(1.0/16)) > 0 {
+			d := newFloat(w).Mul(a, a)
+			d.Add(d, one).Sqrt(d).Add(d, one)
+			a.Quo(a, d)
+			halvings++
+		}
+		y = atanSeries(a, w)
+		y.SetMantExp(y, halvings)
+		if invert {
+			y.Sub(halfPi, y)
+		}
+	}
+	if x.Sign() < 0 {
+		y.Neg(y)
+	}
+	return newFloat(prec).Set(y)
+}
+
+// fpow returns x^y to prec bits, or nil if the result is not a real number.
+// Special cases follow math.Pow.
+func fpow(x, y *big.Float, prec uint) *big.Float {
+	one := big.NewFloat(1)
+	yInt := y.IsInt()
+	yOdd := false
+	if yInt && !y.IsInf() {
+		n, _ := y.Int(nil)
+		yOdd = n.Bit(0) == 1
+	}
+	switch {
+	case y.Sign() == 0 || x.Cmp(one) == 0:
+		return newFloat(prec).SetInt64(1)
+	case y.Cmp(one) == 0:
+		return newFloat(prec).Set(x)
+	case x.Sign() == 0 || x.IsInf():
+		// 0^y and ∞^y are 0 or ∞, negative for negative x and odd y.
+		inf := (x.Sign() == 0) == (y.Sign() < 0)
+		neg := x.Signbit() && yOdd
+		z := newFloat(prec)
+		if inf {
+			z.SetInf(neg)
+		} else if neg {
+			z.Neg(z)
+		}
+		return z
+	case y.IsInf():
+		c := newFloat(x.Prec()).Abs(x).Cmp(one)
+		switch {
+		case c == 0:
+			return newFloat(prec).SetInt64(1)
+		case (c < 0) == (y.Sign() > 0):
+			return newFloat(prec)
+		}
+		return newFloat(prec).SetInf(false)
+	case x.Sign() < 0 && !yInt:
+		return nil
+	}
+
+	// Integral exponents up to 64 bits are computed by repeated squaring,
+	// which is exact while the powers fit in the working precision.
+	if n, acc := y.Int64(); acc == big.Exact && n != math.MinInt64 {
+		w := workPrec(prec, x, uint(bits.Len64(uint64(abs64(n)))))
+		z := newFloat(w).SetInt64(1)
+		p := newFloat(w).Set(x)
+		for m := abs64(n); m > 0; m >>= 1 {
+			if m&1 == 1 {
+				z.Mul(z, p)
+			}
+			p.Mul(p, p)
+		}
+		if n < 0 {
+			z.Quo(one, z)
+		}
+		if z.IsInf() || z.Sign() == 0 {
+			return outOfRange(z.IsInf(), z.Signbit())
+		}
+		return newFloat(prec).Set(z)
+	}
+
+	// x^y = e^(y ln |x|), negated for negative x and odd y. An error of
+	// 2^-w in the exponent t is a relative error of 2^-w in the result, so
+	// t needs as many more bits as its own magnitude.
+	a := newFloat(x.Prec()).Abs(x)
+	t := newFloat(64).Mul(y, flog(a, 64))
+	extra := uint(0)
+	if t.Sign() != 0 && !t.IsInf() {
+		extra = uint(max(exponent(t), 0))
+	}
+	w := workPrec(prec, y, extra)
+	t = newFloat(w).Mul(y, flog(a, w))
+	z := fexp(t, prec)
+	neg := x.Sign() < 0 && yOdd
+	if z.IsInf() || z.Sign() == 0 {
+		return outOfRange(z.IsInf(), neg)
+	}
+	if neg {
+		z.Neg(z)
+	}
+	return z
+}
+
+// fint returns the finite x rounded to an integer in mode.
+func fint(x *big.Float, mode big.RoundingMode) *big.Int {
+	i := roundInt(new(big.Float).Abs(x), absMode(mode, x.Signbit()))
+	if x.Sign() < 0 {
+		i.Neg(i)
+	}
+	return i
+}
+
+// Rounding directions of froundInt.
+const (
+	roundFloor = iota
+	roundCeil
+	roundTrunc
+	roundHalfAway
+)
+
+// froundInt returns x rounded to an integer in the given direction. Ties
+// of roundHalfAway round away from zero, and a zero result keeps the sign
+// of x.
+func froundInt(x *big.Float, dir int) *big.Float {
+	if x.IsInf() || x.IsInt() {
+		return new(big.Float).Copy(x)
+	}
+	i, _ := x.Int(nil) // truncated toward zero
+	switch dir {
+	case roundFloor:
+		if x.Sign() < 0 {
+			i.Sub(i, big.NewInt(1))
+		}
+	case roundCeil:
+		if x.Sign() > 0 {
+			i.Add(i, big.NewInt(1))
+		}
+	case roundHalfAway:
+		frac := newFloat(x.Prec()).Sub(x, new(big.Float).SetInt(i))
+		if frac.Abs(frac).Cmp(big.NewFloat(0.5)) >= 0 {
+			i.Add(i, big.NewInt(int64(x.Sign())))
+		}
+	}
+	z := new(big.Float).SetInt(i)
+	if i.Sign() == 0 && x.Sign() < 0 {
+		z.Neg(z)
+	}
+	return z
+}
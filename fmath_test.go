@@ -0,0 +1,188 @@
+package tmach
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// floatCases are reference values of the floating-point functions, exact to
+// 90 significant digits, about 299 bits. The arguments are exact in binary.
+var floatCases = []struct {
+	opcode uint32
+	x, y   string
+	want   string
+}{
+	{OP_SQRT, "2", "", "1.414213562373095048801688724209698078569671875376948073176679737990732478462107038850387534e+0"},
+	{OP_SQRT, "0.5", "", "7.071067811865475244008443621048490392848359376884740365883398689953662392310535194251937672e-1"},
+	{OP_EXP, "1", "", "2.718281828459045235360287471352662497757247093699959574966967627724076630353547594571382179e+0"},
+	{OP_EXP, "-10.5", "", "2.753644934974715785741109710242551110158986173923072932051393178583859916602317337728829681e-5"},
+	{OP_EXP, "1000", "", "1.970071114017046993888879352243323125316937985323845789952802991385063850782441193474978077e+434"},
+	{OP_LOG, "2", "", "6.931471805599453094172321214581765680755001343602552541206800094933936219696947156058633270e-1"},
+	{OP_LOG, "1e22", "", "5.065687204586900504839581200305601256722423274983300547273321382128659741290175456519193851e+1"},
+	{OP_LOG, "0.75", "", "-2.876820724517809274392190059938274315035097108977610565066656853492929507207804643381108992e-1"},
+	{OP_LOG, "0x1.0000000000000000000000001p0", "", "7.888609052210118054117285652824750789093133780236658015675900880884818306491157115024101103e-31"},
+	{OP_SIN, "1", "", "8.414709848078965066525023216302989996225630607983710656727517099919104043912396689486397435e-1"},
+	{OP_SIN, "-0.5", "", "-4.794255386042030002732879352155713880818033679406006751886166131255350002878148322096312747e-1"},
+	{OP_SIN, "100", "", "-5.063656411097587936565576104597854320650327212906573234433924735943579134194766964992366645e-1"},
+	{OP_SIN, "1e22", "", "-8.522008497671888017727058937530293682617621504100436562565093260259103119920962015354362802e-1"},
+	{OP_COS, "1", "", "5.403023058681397174009366074429766037323104206179222276700972553811003947744717645179518561e-1"},
+	{OP_COS, "1e22", "", "5.232147853951389454975944733847094921409199724393879535272113921042982473767106232834226327e-1"},
+	{OP_COS, "1.5", "", "7.073720166770291008818985143426870908509102756334686942264541719092293457350070064693529896e-2"},
+	{OP_ATAN, "1", "", "7.853981633974483096156608458198757210492923498437764552437361480769541015715522496570087063e-1"},
+	{OP_ATAN, "0.5", "", "4.636476090008061162142562314612144020285370542861202638109330887201978641657417053006002840e-1"},
+	{OP_ATAN, "-10", "", "-1.471127674303734591852875571761730851855306377183238262471963519343880455695553844893404788e+0"},
+	{OP_ATAN, "1e30", "", "1.570796326794896619231321691638751442098584699687552910487472296153908203143104499314017413e+0"},
+	{OP_POW, "2", "0.5", "1.414213562373095048801688724209698078569671875376948073176679737990732478462107038850387534e+0"},
+	{OP_POW, "10", "-3.25", "5.623413251903490803949510397764812314682510430986916640816894237358835686430628489058579845e-4"},
+	{OP_POW, "1.5", "100", "4.065611775352152373972797075670416710103878906323797634290517698787563831961701377171181093e+17"},
+	{OP_POW, "0.75", "1000.5", "9.972269881687948860604999830076154051962265888395148037298466701216585065836873149221695032e-126"},
+}
+
+// parseFloat parses s at precision prec.
+func parseFloat(t *testing.T, s string, prec uint) *big.Float {
+	t.Helper()
+	f, _, err := big.ParseFloat(s, 0, prec, big.ToNearestEven)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// TestFloatFunctions tests that the floating-point functions are correctly
+// rounded in every rounding mode, at the default and at a larger precision.
+func TestFloatFunctions(t *testing.T) {
+	modes := []big.RoundingMode{big.ToNearestEven, big.ToNearestAway, big.ToZero,
+		big.AwayFromZero, big.ToNegativeInf, big.ToPositiveInf}
+	for _, prec := range []uint{FloatPrec, 280} {
+		for _, mode := range modes {
+			for _, c := range floatCases {
+				vm := NewVM()
+				vm.F[1].Set(parseFloat(t, c.x, FloatPrec))
+				if c.y != "" {
+					vm.F[2].Set(parseFloat(t, c.y, FloatPrec))
+				}
+				vm.FPCR = FPControl(prec, mode)
+				if err := vm.Execute(c.opcode<<24 | 8<<20 | 9<<16 | 10<<12); err != nil {
+					t.Errorf("opcode 0x%02X of %s failed: %v", c.opcode, c.x, err)
+					continue
+				}
+				got := vm.F[0]
+				want := parseFloat(t, c.want, 1024)
+				if got.Prec() != prec || !roundedFrom(got, want, prec, mode) {
+					t.Errorf("opcode 0x%02X of %s %s failed at precision %d, %v: expected %s, got %s",
+						c.opcode, c.x, c.y, prec, mode, c.want, got.Text('e', 90))
+				}
+			}
+		}
+	}
+}
+
+// roundedFrom reports whether got is want, a reference value exact to 2^-295
+// relative, rounded to prec bits in mode.
+func roundedFrom(got, want *big.Float, prec uint, mode big.RoundingMode) bool {
+	e := want.MantExp(nil)
+	ulp := new(big.Float).SetMantExp(big.NewFloat(1), e-int(prec))
+	slack := new(big.Float).SetMantExp(big.NewFloat(1), e-295)
+	diff := new(big.Float).SetPrec(1024).Sub(got, want)
+	if want.Sign() < 0 {
+		// Compare magnitudes, so that ToZero rounds down.
+		diff.Neg(diff)
+		mode = absMode(mode, true)
+	}
+	abs := new(big.Float).Abs(diff)
+	switch mode {
+	case big.ToNearestEven, big.ToNearestAway:
+		return abs.Cmp(new(big.Float).Quo(ulp, big.NewFloat(2))) <= 0
+	case big.ToZero, big.ToNegativeInf:
+		return diff.Cmp(slack) <= 0 && abs.Cmp(ulp) < 0
+	default:
+		return diff.Cmp(new(big.Float).Neg(slack)) >= 0 && abs.Cmp(ulp) < 0
+	}
+}
+
+// TestFloatSpecialCases tests exact results, rounding to integers and
+// special values.
+func TestFloatSpecialCases(t *testing.T) {
+	inf := new(big.Float).SetInf(false)
+	tests := []struct {
+		opcode uint32
+		x, y   *big.Float
+		want   *big.Float
+	}{
+		{OP_SQRT, big.NewFloat(16), nil, big.NewFloat(4)},
+		{OP_ABS, big.NewFloat(-3), nil, big.NewFloat(3)},
+		{OP_NEG, big.NewFloat(3), nil, big.NewFloat(-3)},
+		{OP_FLOOR, big.NewFloat(-2.5), nil, big.NewFloat(-3)},
+		{OP_CEIL, big.NewFloat(-2.5), nil, big.NewFloat(-2)},
+		{OP_CEIL, big.NewFloat(-0.5), nil, big.NewFloat(0).Neg(big.NewFloat(0))},
+		{OP_ROUND, big.NewFloat(2.5), nil, big.NewFloat(3)},
+		{OP_ROUND, big.NewFloat(-2.5), nil, big.NewFloat(-3)},
+		{OP_ROUND, big.NewFloat(2.4999), nil, big.NewFloat(2)},
+		{OP_TRUNC, big.NewFloat(-2.7), nil, big.NewFloat(-2)},
+		{OP_FLOOR, inf, nil, inf},
+		{OP_EXP, big.NewFloat(0), nil, big.NewFloat(1)},
+		{OP_EXP, new(big.Float).Neg(inf), nil, big.NewFloat(0)},
+		{OP_LOG, big.NewFloat(1), nil, big.NewFloat(0)},
+		{OP_LOG, big.NewFloat(0), nil, new(big.Float).Neg(inf)},
+		{OP_SIN, big.NewFloat(0), nil, big.NewFloat(0)},
+		{OP_COS, big.NewFloat(0), nil, big.NewFloat(1)},
+		{OP_POW, big.NewFloat(2), big.NewFloat(10), big.NewFloat(1024)},
+		{OP_POW, big.NewFloat(-2), big.NewFloat(3), big.NewFloat(-8)},
+		{OP_POW, big.NewFloat(2), big.NewFloat(-3), big.NewFloat(0.125)},
+		{OP_POW, big.NewFloat(0), big.NewFloat(-1), inf},
+		{OP_POW, big.NewFloat(0.5), inf, big.NewFloat(0)},
+	}
+	for _, tt := range tests {
+		vm := NewVM()
+		vm.F[1].Set(tt.x)
+		if tt.y != nil {
+			vm.F[2].Set(tt.y)
+		}
+		// Exact results must not depend on the rounding mode.
+		vm.FPCR = FPControl(FloatPrec, big.ToZero)
+		if err := vm.Execute(tt.opcode<<24 | 8<<20 | 9<<16 | 10<<12); err != nil {
+			t.Errorf("opcode 0x%02X of %v failed: %v", tt.opcode, tt.x, err)
+			continue
+		}
+		if vm.F[0].Cmp(tt.want) != 0 || vm.F[0].Signbit() != tt.want.Signbit() {
+			t.Errorf("opcode 0x%02X of %v %v failed: expected %v, got %v", tt.opcode, tt.x, tt.y, tt.want, vm.F[0])
+		}
+		if vm.GetFlag(ZF) != (tt.want.Sign() == 0) {
+			t.Errorf("opcode 0x%02X of %v failed: unexpected ZF", tt.opcode, tt.x)
+		}
+		if vm.GetFPFlag(IX) {
+			t.Errorf("opcode 0x%02X of %v failed: IX set for an exact result", tt.opcode, tt.x)
+		}
+	}
+}
+
+// TestFloatFaults tests that results that are not real numbers fault and
+// leave the destination unchanged.
+func TestFloatFaults(t *testing.T) {
+	tests := []struct {
+		opcode uint32
+		x, y   *big.Float
+	}{
+		{OP_SQRT, big.NewFloat(-1), nil},
+		{OP_LOG, big.NewFloat(-1), nil},
+		{OP_SIN, new(big.Float).SetInf(false), nil},
+		{OP_COS, new(big.Float).SetMantExp(big.NewFloat(1), maxTrigExp+1), nil},
+		{OP_POW, big.NewFloat(-2), big.NewFloat(0.5)},
+	}
+	for _, tt := range tests {
+		vm := NewVM()
+		vm.F[0].SetInt64(7)
+		vm.F[1].Set(tt.x)
+		if tt.y != nil {
+			vm.F[2].Set(tt.y)
+		}
+		var f *Fault
+		if err := vm.Execute(tt.opcode<<24 | 8<<20 | 9<<16 | 10<<12); !errors.As(err, &f) || f.Kind != FaultFloat {
+			t.Errorf("opcode 0x%02X of %v failed: expected floating-point fault, got %v", tt.opcode, tt.x, err)
+		}
+		if vm.F[0].Cmp(big.NewFloat(7)) != 0 {
+			t.Errorf("opcode 0x%02X of %v failed: F0 changed to %v", tt.opcode, tt.x, vm.F[0])
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package tmach
+
+// CostTable gives the gas charged for executing each opcode.
+type CostTable [256]uint64
+
+// DefaultCosts charges one unit for register moves and immediates, and more
+// for instructions whose work grows with the 256-bit operands or that touch
+// memory. Division is the most expensive basic integer operation, and
+// modular exponentiation, a loop of up to 512 modular products, and the
+// transcendental functions, which sum series of full-precision terms, cost
+// the most. Floating-point costs hold up to MaxFloatPrec bits of precision,
+// the most FPCR can select.
+var DefaultCosts = CostTable{
+	OP_NOP: 1,
+
+	OP_LOAD:  4,
+	OP_STORE: 4,
+
+	OP_ADD:  2,
+	OP_SUB:  2,
+	OP_MUL:  8,
+	OP_DIV:  16,
+	OP_MOD:  16,
+	OP_SDIV: 16,
+	OP_SMOD: 16,
+	OP_CMP:  2,
+	OP_SCMP: 2,
+	OP_ITOF: 4,
+	OP_FTOI: 4,
+
+	OP_ABS:   2,
+	OP_NEG:   2,
+	OP_FLOOR: 4,
+	OP_CEIL:  4,
+	OP_ROUND: 4,
+	OP_TRUNC: 4,
+	OP_SQRT:  16,
+	OP_EXP:   128,
+	OP_LOG:   128,
+	OP_SIN:   128,
+	OP_COS:   128,
+	OP_ATAN:  128,
+	OP_POW:   256,
+
+	OP_AND: 2,
+	OP_OR:  2,
+	OP_XOR: 2,
+	OP_NOT: 2,
+	OP_LSH: 2,
+	OP_RSH: 2,
+	OP_CSH: 2,
+	OP_SAR: 2,
+
+	OP_LSHV: 2,
+	OP_RSHV: 2,
+	OP_SARV: 2,
+	OP_ROL:  2,
+	OP_ROR:  2,
+	OP_ROLV: 2,
+	OP_RORV: 2,
+
+	OP_JMP: 1,
+	OP_JZ:  1,
+	OP_JNZ: 1,
+	OP_JGT: 1,
+	OP_JLT: 1,
+	OP_JEQ: 1,
+	OP_JNE: 1,
+	OP_JGE: 1,
+	OP_JLE: 1,
+
+	OP_JMPA: 1,
+	OP_JMPJ: 1,
+	OP_BRA:  1,
+	OP_BZ:   1,
+	OP_BNZ:  1,
+	OP_BEQ:  1,
+	OP_BNE:  1,
+	OP_BGT:  1,
+	OP_BLT:  1,
+	OP_BGE:  1,
+	OP_BLE:  1,
+
+	OP_LDI:   1,
+	OP_LDW:   2,
+	OP_MOV:   1,
+	OP_RTOA:  1,
+	OP_ATOR:  1,
+	OP_MOVA:  1,
+	OP_LDA:   1,
+	OP_ADDA:  1,
+	OP_ADDAR: 1,
+
+	OP_PUSH:  4,
+	OP_POP:   4,
+	OP_PUSHA: 2,
+	OP_POPA:  2,
+	OP_CALL:  2,
+	OP_RET:   2,
+	OP_GETSP: 1,
+	OP_SETSP: 1,
+
+	OP_GETFPCR: 1,
+	OP_SETFPCR: 1,
+
+	OP_ADDMOD: 4,
+	OP_SUBMOD: 4,
+	OP_MULMOD: 16,
+	OP_EXPMOD: 512,
+	OP_INVMOD: 64,
+
+	OP_POPCNT: 2,
+	OP_CLZ:    2,
+	OP_CTZ:    2,
+	OP_BSWAP:  2,
+	OP_BT:     2,
+	OP_BTS:    2,
+	OP_BTR:    2,
+	OP_BTC:    2,
+	OP_BTI:    2,
+	OP_BTSI:   2,
+	OP_BTRI:   2,
+	OP_BTCI:   2,
+
+	OP_SYSCALL: 8,
+	OP_HALT:    1,
+}
+
+// UniformCosts returns a table charging cost for every opcode. With a cost
+// of 1, VM.Gas counts instructions.
+func UniformCosts(cost uint64) *CostTable {
+	var t CostTable
+	for i := range t {
+		t[i] = cost
+	}
+	return &t
+}
+
+// IOWordCost is the gas the default read and write system calls charge for
+// each 32-byte word of their buffer, on top of the cost of SYSCALL, the same
+// rate as LOAD and STORE.
+const IOWordCost = 4
+
+// UseGas deducts n from Gas for work a system call does beyond the cost of
+// SYSCALL, such as copying a buffer. If Gas does not cover n, it returns a
+// FaultOutOfGas fault and leaves Gas unchanged. Without Costs it does
+// nothing.
+func (vm *VM) UseGas(n uint64) error {
+	if vm.Costs == nil {
+		return nil
+	}
+	if n > vm.Gas {
+		return &Fault{Kind: FaultOutOfGas}
+	}
+	vm.Gas -= n
+	return nil
+}
+
+// charge deducts the cost of instruction from vm.Gas. If the remaining gas
+// does not cover it, charge returns a FaultOutOfGas fault and leaves Gas
+// unchanged.
+func (vm *VM) charge(instruction uint32) error {
+	cost := vm.Costs[instruction>>24]
+	if cost > vm.Gas {
+		return &Fault{Kind: FaultOutOfGas, PC: vm.PC, Instruction: instruction}
+	}
+	vm.Gas -= cost
+	return nil
+}
@@ -0,0 +1,102 @@
+package tmach
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// TestGas tests that an infinite loop stops when its gas runs out.
+func TestGas(t *testing.T) {
+	vm := NewVM()
+	vm.LoadProgram([]uint32{
+		OP_NOP << 24,
+		OP_JMP<<24 | 0x000000, // JMP 0
+	})
+	vm.Costs = UniformCosts(1)
+	vm.Gas = 101
+
+	var f *Fault
+	if err := vm.Run(); !errors.As(err, &f) || f.Kind != FaultOutOfGas {
+		t.Fatalf("Run failed: expected out of gas fault, got %v", err)
+	}
+	// 50 iterations of two instructions, then the NOP of the 51st.
+	if vm.Gas != 0 || vm.PC != 1 || f.PC != 1 {
+		t.Errorf("Run failed: expected Gas = 0 at PC 1, got Gas = %d, PC = %d", vm.Gas, vm.PC)
+	}
+
+	// Refilling the gas resumes execution at the faulting instruction.
+	vm.Gas = 1
+	if err := vm.Step(); err != nil || vm.PC != 0 {
+		t.Errorf("Step after refill failed: expected PC 0, got %v, PC = %d", err, vm.PC)
+	}
+}
+
+// TestGasCosts tests that big-number operations cost more than NOP.
+func TestGasCosts(t *testing.T) {
+	if DefaultCosts[OP_MUL] <= DefaultCosts[OP_NOP] || DefaultCosts[OP_DIV] <= DefaultCosts[OP_MUL] {
+		t.Errorf("expected NOP < MUL < DIV, got %d, %d, %d",
+			DefaultCosts[OP_NOP], DefaultCosts[OP_MUL], DefaultCosts[OP_DIV])
+	}
+	for op := range 256 {
+		if DefaultCosts[op] != 0 {
+			continue
+		}
+		var f *Fault
+		if err := NewVM().Execute(uint32(op) << 24); !errors.As(err, &f) || f.Kind != FaultOpcode {
+			t.Errorf("opcode 0x%02X is defined but has no cost", op)
+		}
+	}
+
+	vm := NewVM()
+	vm.LoadProgram([]uint32{
+		OP_MUL<<24 | 0<<20 | 1<<16 | 2<<12, // MUL R0, R1, R2
+		OP_DIV<<24 | 0<<20 | 1<<16 | 2<<12, // DIV R0, R1, R2
+	})
+	vm.Costs = &DefaultCosts
+	vm.Gas = DefaultCosts[OP_MUL] + DefaultCosts[OP_DIV] - 1
+	if err := vm.Step(); err != nil {
+		t.Fatalf("MUL failed: %v", err)
+	}
+	var f *Fault
+	if err := vm.Step(); !errors.As(err, &f) || f.Kind != FaultOutOfGas {
+		t.Errorf("DIV failed: expected out of gas fault, got %v", err)
+	}
+	if vm.Gas != DefaultCosts[OP_DIV]-1 || vm.PC != 1 {
+		t.Errorf("DIV failed: expected Gas = %d at PC 1, got Gas = %d, PC = %d", DefaultCosts[OP_DIV]-1, vm.Gas, vm.PC)
+	}
+}
+
+// TestGasFloatPrecision tests that a program cannot raise the FPCR precision
+// beyond MaxFloatPrec, where the flat floating-point costs would no longer
+// bound its work, and that functions at MaxFloatPrec are charged as usual.
+func TestGasFloatPrecision(t *testing.T) {
+	vm := NewVM()
+	vm.LoadProgram([]uint32{
+		OP_SETFPCR<<24 | 1<<16,     // MOV FPCR, R1
+		OP_SIN<<24 | 8<<20 | 9<<16, // SIN F0, F1
+		OP_SETFPCR<<24 | 2<<16,     // MOV FPCR, R2
+	})
+	vm.Costs = &DefaultCosts
+	vm.Gas = DefaultCosts[OP_SETFPCR]*2 + DefaultCosts[OP_SIN]
+	vm.R[1].SetUint64(uint64(FPControl(MaxFloatPrec, big.ToNearestEven)))
+	vm.R[2].SetUint64(uint64(FPControl(65535, big.ToNearestEven)))
+	vm.F[1].SetFloat64(12345.678)
+
+	for range 2 {
+		if err := vm.Step(); err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+	}
+	if vm.F[0].Prec() != MaxFloatPrec || vm.Gas != DefaultCosts[OP_SETFPCR] {
+		t.Errorf("SIN failed: expected %d bits with %d gas left, got %d bits with %d gas left",
+			MaxFloatPrec, DefaultCosts[OP_SETFPCR], vm.F[0].Prec(), vm.Gas)
+	}
+	var f *Fault
+	if err := vm.Step(); !errors.As(err, &f) || f.Kind != FaultFloat {
+		t.Errorf("MOV FPCR, Rs failed: expected floating-point fault for 65535 bits, got %v", err)
+	}
+	if vm.Precision() != MaxFloatPrec {
+		t.Errorf("MOV FPCR, Rs failed: expected precision %d, got %d", MaxFloatPrec, vm.Precision())
+	}
+}
@@ -0,0 +1,132 @@
+package tmach
+
+import "math/big"
+
+// Inst is a decoded instruction word. Every field is extracted regardless of
+// the opcode; which ones are meaningful depends on the instruction.
+type Inst struct {
+	PC     uint32 // Address of the instruction
+	Word   uint32 // Encoded instruction word
+	Opcode uint32 // Bits 24-31
+	Rd     uint32 // Bits 20-23
+	Rs     uint32 // Bits 16-19
+	Rt     uint32 // Bits 12-15
+	Ax     uint32 // Bits 8-11
+	Imm    uint32 // Bits 0-7
+	Addr   uint32 // Bits 0-23, for jumps
+	Offset int32  // Bits 0-23 sign-extended, for relative branches
+}
+
+// Decode splits an instruction word into its fields. PC is left zero.
+func Decode(word uint32) Inst {
+	return Inst{
+		Word:   word,
+		Opcode: FieldOpcode.Get(word),
+		Rd:     FieldRd.Get(word),
+		Rs:     FieldRs.Get(word),
+		Rt:     FieldRt.Get(word),
+		Ax:     FieldAx.Get(word),
+		Imm:    FieldImm8.Get(word),
+		Addr:   FieldAddr.Get(word),
+		Offset: int32(word<<8) >> 8,
+	}
+}
+
+// Hook observes instruction execution. When VM.Hook is set, Step calls
+// Before with each instruction it is about to execute and After once the
+// instruction has executed, with the changes it made to the registers.
+// Hooks must not modify the VM.
+type Hook interface {
+	Before(vm *VM, in Inst)
+	After(vm *VM, in Inst, d *Delta)
+}
+
+// IntDelta records a change to an integer register.
+type IntDelta struct {
+	Reg      int
+	Old, New *big.Int
+}
+
+// FloatDelta records a change to a floating-point register.
+type FloatDelta struct {
+	Reg      int
+	Old, New *big.Float
+}
+
+// AddrDelta records a change to an address register.
+type AddrDelta struct {
+	Reg      int
+	Old, New uint32
+}
+
+// Delta describes the effect of one instruction on the registers. It and
+// the values it points to are only valid during the call to Hook.After;
+// hooks that keep them must copy them.
+type Delta struct {
+	R             []IntDelta
+	F             []FloatDelta
+	A             []AddrDelta
+	OldSR, SR     byte
+	OldFPCR, FPCR uint32
+	OldJ, J       uint32
+	OldSP, SP     uint32
+	PC            uint32 // PC after the instruction
+	Err           error  // Error returned by Step, if any
+}
+
+// hookState holds the registers as they were before the instruction being
+// observed, and the Delta passed to the hook.
+type hookState struct {
+	r     [8]big.Int
+	f     [8]big.Float
+	a     [8]uint32
+	sr    byte
+	fpcr  uint32
+	j, sp uint32
+	delta Delta
+}
+
+func (s *hookState) save(vm *VM) {
+	for i := range vm.R {
+		s.r[i].Set(vm.R[i])
+		s.f[i].Copy(vm.F[i])
+	}
+	s.a, s.sr, s.fpcr, s.j, s.sp = vm.A, vm.SR, vm.FPCR, vm.J, vm.SP
+}
+
+func (s *hookState) diff(vm *VM, err error) *Delta {
+	d := &s.delta
+	d.R, d.F, d.A = d.R[:0], d.F[:0], d.A[:0]
+	for i := range vm.R {
+		if s.r[i].Cmp(vm.R[i]) != 0 {
+			d.R = append(d.R, IntDelta{i, &s.r[i], vm.R[i]})
+		}
+		if s.f[i].Cmp(vm.F[i]) != 0 || s.f[i].Signbit() != vm.F[i].Signbit() {
+			d.F = append(d.F, FloatDelta{i, &s.f[i], vm.F[i]})
+		}
+		if s.a[i] != vm.A[i] {
+			d.A = append(d.A, AddrDelta{i, s.a[i], vm.A[i]})
+		}
+	}
+	d.OldSR, d.SR = s.sr, vm.SR
+	d.OldFPCR, d.FPCR = s.fpcr, vm.FPCR
+	d.OldJ, d.J = s.j, vm.J
+	d.OldSP, d.SP = s.sp, vm.SP
+	d.PC = vm.PC
+	d.Err = err
+	return d
+}
+
+// stepHooked executes instruction, reporting it to vm.Hook.
+func (vm *VM) stepHooked(instruction uint32) error {
+	in := Decode(instruction)
+	in.PC = vm.PC
+	vm.Hook.Before(vm, in)
+	if vm.hook == nil {
+		vm.hook = new(hookState)
+	}
+	vm.hook.save(vm)
+	err := vm.step(instruction)
+	vm.Hook.After(vm, in, vm.hook.diff(vm, err))
+	return err
+}
@@ -0,0 +1,55 @@
+package tmach
+
+import "math/big"
+
+// Integer registers hold 256-bit words. Every integer instruction reduces its
+// result modulo 2^256, so a register always holds a value in [0, 2^256).
+// Instructions that care about sign interpret the word in two's complement,
+// giving the signed range [-2^255, 2^255).
+
+var (
+	mod256    = new(big.Int).Lsh(big.NewInt(1), 256) // 2^256
+	mask256   = new(big.Int).Sub(mod256, big.NewInt(1))
+	minInt256 = new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), 255)) // -2^255
+	maxInt256 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(1))
+	mask32    = big.NewInt(0xFFFFFFFF)
+)
+
+// wrap reduces x modulo 2^256 in place and returns it.
+func wrap(x *big.Int) *big.Int {
+	return x.And(x, mask256) // And uses two's complement for negative x
+}
+
+// unsigned returns the unsigned 256-bit word of x.
+func unsigned(x *big.Int) *big.Int {
+	return new(big.Int).And(x, mask256)
+}
+
+// signed returns the two's-complement signed value of the 256-bit word of x.
+func signed(x *big.Int) *big.Int {
+	v := unsigned(x)
+	if v.Bit(255) == 1 {
+		v.Sub(v, mod256)
+	}
+	return v
+}
+
+// fitsSigned reports whether x lies in the signed 256-bit range.
+func fitsSigned(x *big.Int) bool {
+	return x.Cmp(minInt256) >= 0 && x.Cmp(maxInt256) <= 0
+}
+
+// low32 returns the low 32 bits of the 256-bit word of x.
+func low32(x *big.Int) uint32 {
+	return uint32(new(big.Int).And(x, mask32).Uint64())
+}
+
+// Unsigned returns the value of integer register R[r] as an unsigned 256-bit integer.
+func (vm *VM) Unsigned(r int) *big.Int {
+	return unsigned(vm.R[r])
+}
+
+// Signed returns the value of integer register R[r] as a signed (two's-complement) 256-bit integer.
+func (vm *VM) Signed(r int) *big.Int {
+	return signed(vm.R[r])
+}
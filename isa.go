@@ -0,0 +1,793 @@
+package tmach
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//go:generate go run ./cmd/tmach-isa -o ISA.md
+
+// ===================================================================
+// Instruction Set Table
+// ===================================================================
+//
+// ISA is the single description of the instruction set. Execute dispatches
+// through it and checks register operands against its forms, the assembler
+// and disassembler in package asm encode and decode its forms, and ISA.md
+// is generated from it by cmd/tmach-isa.
+
+// OperandKind is the kind of value an instruction operand accepts.
+type OperandKind int
+
+const (
+	OperandR     OperandKind = iota // integer register R0-R7, encoded as 0-7
+	OperandF                        // floating-point register F0-F7, encoded as 8-15
+	OperandA                        // address register A0-A7, encoded as 0-7
+	OperandMem                      // memory operand [Ax], encoded as 0-7
+	OperandImm                      // unsigned immediate
+	OperandSimm                     // signed immediate, in two's complement
+	OperandCount                    // word count 1-8, encoded as count-1
+	OperandAddr                     // code address
+	OperandRel                      // code address relative to the instruction, in two's complement
+	OperandFixed                    // special register implied by the opcode, e.g. SP
+)
+
+func (k OperandKind) String() string {
+	switch k {
+	case OperandR:
+		return "integer register"
+	case OperandF:
+		return "floating-point register"
+	case OperandA:
+		return "address register"
+	case OperandMem:
+		return "memory operand [Ax]"
+	case OperandImm:
+		return "immediate"
+	case OperandSimm:
+		return "signed immediate"
+	case OperandCount:
+		return "word count 1-8"
+	case OperandAddr:
+		return "address"
+	case OperandRel:
+		return "label or offset"
+	}
+	return "operand"
+}
+
+// Field is a bit field of an instruction word.
+type Field struct {
+	Name         string
+	Shift, Width uint
+}
+
+// Mask returns the mask of a field value, before shifting.
+func (f Field) Mask() uint32 { return 1<<f.Width - 1 }
+
+// Get extracts the field from word.
+func (f Field) Get(word uint32) uint32 { return word >> f.Shift & f.Mask() }
+
+// Instruction fields. The opcode is always bits 24-31.
+var (
+	FieldOpcode = Field{"Opcode", 24, 8}
+	FieldRd     = Field{"Rd", 20, 4}
+	FieldRs     = Field{"Rs", 16, 4}
+	FieldRt     = Field{"Rt", 12, 4}
+	FieldAx     = Field{"Ax", 8, 4}
+	FieldRm     = Field{"Rm", 8, 4}
+	FieldImm3   = Field{"Imm3", 0, 3}
+	FieldImm8   = Field{"Imm8", 0, 8}
+	FieldImm16  = Field{"Imm16", 0, 16}
+	FieldImm20  = Field{"Imm20", 0, 20}
+	FieldAddr   = Field{"Addr", 0, 24}
+)
+
+// Operand describes one operand of an instruction form. An operand is
+// usually encoded into a single field, but shorthand forms such as
+// "LSH Rd, N" write the same register into several fields, and special
+// registers are implied by the opcode and take no field at all.
+type Operand struct {
+	Kind   OperandKind
+	Fields []Field
+	Name   string // Register name, for OperandFixed
+}
+
+// Syntax returns the placeholder for the operand in the assembly syntax of
+// a form, such as Rd, [Ax] or Imm20.
+func (o Operand) Syntax() string {
+	var f Field
+	if len(o.Fields) > 0 {
+		f = o.Fields[0]
+	}
+	switch o.Kind {
+	case OperandR, OperandF, OperandA:
+		return "RFA"[o.Kind:o.Kind+1] + f.Name[1:]
+	case OperandMem:
+		return "[" + f.Name + "]"
+	case OperandImm:
+		return fmt.Sprintf("Imm%d", f.Width)
+	case OperandSimm:
+		return fmt.Sprintf("Simm%d", f.Width)
+	case OperandCount:
+		return "N"
+	case OperandAddr:
+		return "Addr"
+	case OperandRel:
+		return "Offset"
+	}
+	return o.Name
+}
+
+// accepts reports whether the value v of a field holding the operand is
+// valid for its kind.
+func (o Operand) accepts(v uint32) bool {
+	switch o.Kind {
+	case OperandR, OperandA, OperandMem:
+		return v < 8
+	case OperandF:
+		return v >= 8
+	}
+	return true
+}
+
+// Form is one syntactic form of an instruction. An opcode may have several
+// forms, e.g. ADD on integer or on floating-point registers.
+type Form struct {
+	Mnemonic string
+	Operands []Operand
+}
+
+// String returns the assembly syntax of the form, e.g. "ADD Rd, Rs, Rt".
+func (f Form) String() string {
+	if len(f.Operands) == 0 {
+		return f.Mnemonic
+	}
+	args := make([]string, len(f.Operands))
+	for i, o := range f.Operands {
+		args[i] = o.Syntax()
+	}
+	return f.Mnemonic + " " + strings.Join(args, ", ")
+}
+
+// Encoding returns the layout of the form's instruction word from the most
+// significant bit, e.g. "Opcode (8) | Rd (4) | Rs (4) | Rt (4) | Reserved (12)".
+func (f Form) Encoding() string {
+	type part struct {
+		name  string
+		field Field
+	}
+	parts := []part{{"Opcode", FieldOpcode}}
+	for _, o := range f.Operands {
+		name := o.Syntax()
+		switch o.Kind {
+		case OperandMem:
+			name = o.Fields[0].Name
+		case OperandCount:
+			name = "N−1"
+		}
+		for _, fl := range o.Fields {
+			parts = append(parts, part{name, fl})
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].field.Shift > parts[j].field.Shift })
+
+	var s []string
+	next := uint(32)
+	for _, p := range parts {
+		if end := p.field.Shift + p.field.Width; end < next {
+			s = append(s, fmt.Sprintf("Reserved (%d)", next-end))
+		}
+		s = append(s, fmt.Sprintf("%s (%d)", p.name, p.field.Width))
+		next = p.field.Shift
+	}
+	if next > 0 {
+		s = append(s, fmt.Sprintf("Reserved (%d)", next))
+	}
+	return strings.Join(s, " | ")
+}
+
+// matches reports whether the operand fields of word are valid for the form.
+// Reserved bits are not checked.
+func (f Form) matches(word uint32) bool {
+	for _, o := range f.Operands {
+		for _, fl := range o.Fields {
+			if v := fl.Get(word); v != o.Fields[0].Get(word) || !o.accepts(v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Op describes an opcode: its assembly forms and how it executes.
+type Op struct {
+	Opcode  uint32
+	Summary string // One-line description of the effect
+	Forms   []Form
+	exec    func(vm *VM, in Inst) error
+}
+
+// Matches reports whether word is a valid instruction of op, that is, its
+// operand fields match one of the forms of op.
+func (op *Op) Matches(word uint32) bool {
+	for _, f := range op.Forms {
+		if f.matches(word) {
+			return true
+		}
+	}
+	return false
+}
+
+// opcodes indexes ISA by opcode.
+var opcodes [256]*Op
+
+func init() {
+	for _, op := range ISA {
+		if opcodes[op.Opcode] != nil {
+			panic(fmt.Sprintf("tmach: opcode 0x%02X defined twice", op.Opcode))
+		}
+		opcodes[op.Opcode] = op
+	}
+}
+
+// Lookup returns the description of opcode, or nil if it is not defined.
+func Lookup(opcode uint32) *Op {
+	if opcode >= uint32(len(opcodes)) {
+		return nil
+	}
+	return opcodes[opcode]
+}
+
+// operand returns an Operand of kind k encoded into fields.
+func operand(k OperandKind, fields ...Field) Operand {
+	return Operand{Kind: k, Fields: fields}
+}
+
+// fixed returns an operand naming a special register.
+func fixed(name string) Operand {
+	return Operand{Kind: OperandFixed, Name: name}
+}
+
+// form returns a Form of mnemonic with the given operands.
+func form(mnemonic string, operands ...Operand) Form {
+	return Form{mnemonic, operands}
+}
+
+// rrr returns the form "OP Rd, Rs, Rt" on registers of kind k.
+func rrr(mnemonic string, k OperandKind) Form {
+	return form(mnemonic, operand(k, FieldRd), operand(k, FieldRs), operand(k, FieldRt))
+}
+
+// modular returns the form "OP Rd, Rs, Rt, Rm" of a modular operation.
+func modular(mnemonic string) Form {
+	return form(mnemonic, operand(OperandR, FieldRd), operand(OperandR, FieldRs), operand(OperandR, FieldRt),
+		operand(OperandR, FieldRm))
+}
+
+// ff returns the form "OP Fd, Fs" of a floating-point function.
+func ff(mnemonic string) Form {
+	return form(mnemonic, operand(OperandF, FieldRd), operand(OperandF, FieldRs))
+}
+
+// shift returns the forms "OP Rd, Rs, N" and the shorthand "OP Rd, N", which
+// shifts Rd in place.
+func shift(mnemonic string) []Form {
+	return []Form{
+		form(mnemonic, operand(OperandR, FieldRd), operand(OperandR, FieldRs), operand(OperandImm, FieldImm8)),
+		form(mnemonic, operand(OperandR, FieldRd, FieldRs), operand(OperandImm, FieldImm8)),
+	}
+}
+
+// shiftReg returns the forms "OP Rd, Rs, Rt" and the shorthand "OP Rd, Rt",
+// the variants of shift taking the count from a register.
+func shiftReg(mnemonic string) []Form {
+	return []Form{
+		rrr(mnemonic, OperandR),
+		form(mnemonic, operand(OperandR, FieldRd, FieldRs), operand(OperandR, FieldRt)),
+	}
+}
+
+// rr returns the form "OP Rd, Rs" on integer registers.
+func rr(mnemonic string) Form {
+	return form(mnemonic, operand(OperandR, FieldRd), operand(OperandR, FieldRs))
+}
+
+// jump returns the form "OP Addr".
+func jump(mnemonic string) []Form {
+	return []Form{form(mnemonic, operand(OperandAddr, FieldAddr))}
+}
+
+// branch returns the form "OP Offset", a PC-relative branch.
+func branch(mnemonic string) []Form {
+	return []Form{form(mnemonic, operand(OperandRel, FieldAddr))}
+}
+
+// ISA lists the opcodes of the instruction set. The assembler tries the forms
+// of a mnemonic in this order.
+var ISA = []*Op{
+	{OP_NOP, "No operation", []Form{form("NOP")}, func(vm *VM, in Inst) error {
+		return nil
+	}},
+
+	// Memory access
+	{OP_LOAD, "Rd or Fd = the 32 bytes at [Ax]", []Form{
+		form("LOAD", operand(OperandR, FieldRd), operand(OperandMem, FieldAx)),
+		form("LOAD", operand(OperandF, FieldRd), operand(OperandMem, FieldAx)),
+	}, func(vm *VM, in Inst) error {
+		return vm.Load(int(in.Rd), int(in.Ax))
+	}},
+	{OP_STORE, "[Ax] = Rs or Fs as 32 bytes", []Form{
+		form("STORE", operand(OperandR, FieldRs), operand(OperandMem, FieldAx)),
+		form("STORE", operand(OperandF, FieldRs), operand(OperandMem, FieldAx)),
+	}, func(vm *VM, in Inst) error {
+		return vm.Store(int(in.Rs), int(in.Ax))
+	}},
+
+	// Arithmetic
+	{OP_ADD, "Rd = Rs + Rt", []Form{rrr("ADD", OperandR), rrr("ADD", OperandF)}, func(vm *VM, in Inst) error {
+		vm.Add(int(in.Rd), int(in.Rs), int(in.Rt))
+		return nil
+	}},
+	{OP_SUB, "Rd = Rs - Rt", []Form{rrr("SUB", OperandR), rrr("SUB", OperandF)}, func(vm *VM, in Inst) error {
+		vm.Sub(int(in.Rd), int(in.Rs), int(in.Rt))
+		return nil
+	}},
+	{OP_MUL, "Rd = Rs × Rt", []Form{rrr("MUL", OperandR), rrr("MUL", OperandF)}, func(vm *VM, in Inst) error {
+		vm.Mul(int(in.Rd), int(in.Rs), int(in.Rt))
+		return nil
+	}},
+	{OP_DIV, "Rd = Rs / Rt, unsigned for integers; sets DF if Rt is zero", []Form{rrr("DIV", OperandR), rrr("DIV", OperandF)}, func(vm *VM, in Inst) error {
+		vm.Div(int(in.Rd), int(in.Rs), int(in.Rt))
+		return nil
+	}},
+	{OP_MOD, "Rd = Rs mod Rt, unsigned; sets DF if Rt is zero", []Form{rrr("MOD", OperandR)}, func(vm *VM, in Inst) error {
+		return vm.Mod(int(in.Rd), int(in.Rs), int(in.Rt))
+	}},
+	{OP_SDIV, "Rd = Rs / Rt, signed, truncating toward zero", []Form{rrr("SDIV", OperandR)}, func(vm *VM, in Inst) error {
+		vm.SDiv(int(in.Rd), int(in.Rs), int(in.Rt))
+		return nil
+	}},
+	{OP_SMOD, "Rd = Rs rem Rt, signed, with the sign of Rs", []Form{rrr("SMOD", OperandR)}, func(vm *VM, in Inst) error {
+		vm.SMod(int(in.Rd), int(in.Rs), int(in.Rt))
+		return nil
+	}},
+
+	// Modular arithmetic
+	{OP_ADDMOD, "Rd = (Rs + Rt) mod Rm, unsigned; sets DF if Rm is zero", []Form{modular("ADDMOD")}, func(vm *VM, in Inst) error {
+		vm.AddMod(int(in.Rd), int(in.Rs), int(in.Rt), int(FieldRm.Get(in.Word)))
+		return nil
+	}},
+	{OP_SUBMOD, "Rd = (Rs - Rt) mod Rm, unsigned; sets DF if Rm is zero", []Form{modular("SUBMOD")}, func(vm *VM, in Inst) error {
+		vm.SubMod(int(in.Rd), int(in.Rs), int(in.Rt), int(FieldRm.Get(in.Word)))
+		return nil
+	}},
+	{OP_MULMOD, "Rd = (Rs × Rt) mod Rm, unsigned; sets DF if Rm is zero", []Form{modular("MULMOD")}, func(vm *VM, in Inst) error {
+		vm.MulMod(int(in.Rd), int(in.Rs), int(in.Rt), int(FieldRm.Get(in.Word)))
+		return nil
+	}},
+	{OP_EXPMOD, "Rd = Rs^Rt mod Rm, unsigned; sets DF if Rm is zero", []Form{modular("EXPMOD")}, func(vm *VM, in Inst) error {
+		vm.ExpMod(int(in.Rd), int(in.Rs), int(in.Rt), int(FieldRm.Get(in.Word)))
+		return nil
+	}},
+	{OP_INVMOD, "Rd = inverse of Rs mod Rt; sets DF if Rt is zero, faults if there is none", []Form{rrr("INVMOD", OperandR)}, func(vm *VM, in Inst) error {
+		return vm.InvMod(int(in.Rd), int(in.Rs), int(in.Rt))
+	}},
+
+	// Comparison
+	{OP_CMP, "Compare Rs with Rt, unsigned for integers; sets LT, EQ or GT", []Form{
+		form("CMP", operand(OperandR, FieldRs), operand(OperandR, FieldRt)),
+		form("CMP", operand(OperandF, FieldRs), operand(OperandF, FieldRt)),
+	}, func(vm *VM, in Inst) error {
+		vm.Compare(int(in.Rs), int(in.Rt))
+		return nil
+	}},
+	{OP_SCMP, "Compare Rs with Rt as signed integers; sets LT, EQ or GT", []Form{
+		form("SCMP", operand(OperandR, FieldRs), operand(OperandR, FieldRt)),
+	}, func(vm *VM, in Inst) error {
+		vm.SCompare(int(in.Rs), int(in.Rt))
+		return nil
+	}},
+
+	// Type conversion
+	{OP_ITOF, "Fd = Rs as a signed integer", []Form{
+		form("ITOF", operand(OperandF, FieldRd), operand(OperandR, FieldRs)),
+	}, func(vm *VM, in Inst) error {
+		vm.ITOF(int(in.Rd-8), int(in.Rs))
+		return nil
+	}},
+	{OP_FTOI, "Rd = Fs rounded to an integer in the FPCR rounding mode", []Form{
+		form("FTOI", operand(OperandR, FieldRd), operand(OperandF, FieldRs)),
+	}, func(vm *VM, in Inst) error {
+		vm.FTOI(int(in.Rd), int(in.Rs-8))
+		return nil
+	}},
+
+	// Floating-point functions
+	{OP_SQRT, "Fd = √Fs", []Form{ff("SQRT")}, func(vm *VM, in Inst) error {
+		return vm.Sqrt(int(in.Rd-8), int(in.Rs-8))
+	}},
+	{OP_ABS, "Fd = |Fs|", []Form{ff("ABS")}, func(vm *VM, in Inst) error {
+		vm.Abs(int(in.Rd-8), int(in.Rs-8))
+		return nil
+	}},
+	{OP_NEG, "Fd = -Fs", []Form{ff("NEG")}, func(vm *VM, in Inst) error {
+		vm.Neg(int(in.Rd-8), int(in.Rs-8))
+		return nil
+	}},
+	{OP_FLOOR, "Fd = Fs rounded toward -∞ to an integer", []Form{ff("FLOOR")}, func(vm *VM, in Inst) error {
+		vm.Floor(int(in.Rd-8), int(in.Rs-8))
+		return nil
+	}},
+	{OP_CEIL, "Fd = Fs rounded toward +∞ to an integer", []Form{ff("CEIL")}, func(vm *VM, in Inst) error {
+		vm.Ceil(int(in.Rd-8), int(in.Rs-8))
+		return nil
+	}},
+	{OP_ROUND, "Fd = Fs rounded to the nearest integer, ties away from zero", []Form{ff("ROUND")}, func(vm *VM, in Inst) error {
+		vm.Round(int(in.Rd-8), int(in.Rs-8))
+		return nil
+	}},
+	{OP_TRUNC, "Fd = Fs rounded toward zero to an integer", []Form{ff("TRUNC")}, func(vm *VM, in Inst) error {
+		vm.Trunc(int(in.Rd-8), int(in.Rs-8))
+		return nil
+	}},
+	{OP_EXP, "Fd = e^Fs", []Form{ff("EXP")}, func(vm *VM, in Inst) error {
+		return vm.Exp(int(in.Rd-8), int(in.Rs-8))
+	}},
+	{OP_LOG, "Fd = ln Fs", []Form{ff("LOG")}, func(vm *VM, in Inst) error {
+		return vm.Log(int(in.Rd-8), int(in.Rs-8))
+	}},
+	{OP_SIN, "Fd = sin Fs", []Form{ff("SIN")}, func(vm *VM, in Inst) error {
+		return vm.Sin(int(in.Rd-8), int(in.Rs-8))
+	}},
+	{OP_COS, "Fd = cos Fs", []Form{ff("COS")}, func(vm *VM, in Inst) error {
+		return vm.Cos(int(in.Rd-8), int(in.Rs-8))
+	}},
+	{OP_ATAN, "Fd = atan Fs", []Form{ff("ATAN")}, func(vm *VM, in Inst) error {
+		return vm.Atan(int(in.Rd-8), int(in.Rs-8))
+	}},
+	{OP_POW, "Fd = Fs^Ft", []Form{rrr("POW", OperandF)}, func(vm *VM, in Inst) error {
+		return vm.Pow(int(in.Rd-8), int(in.Rs-8), int(in.Rt-8))
+	}},
+
+	// Logical and bitwise
+	{OP_AND, "Rd = Rs & Rt", []Form{rrr("AND", OperandR)}, func(vm *VM, in Inst) error {
+		vm.And(int(in.Rd), int(in.Rs), int(in.Rt))
+		return nil
+	}},
+	{OP_OR, "Rd = Rs | Rt", []Form{rrr("OR", OperandR)}, func(vm *VM, in Inst) error {
+		vm.Or(int(in.Rd), int(in.Rs), int(in.Rt))
+		return nil
+	}},
+	{OP_XOR, "Rd = Rs ^ Rt", []Form{rrr("XOR", OperandR)}, func(vm *VM, in Inst) error {
+		vm.Xor(int(in.Rd), int(in.Rs), int(in.Rt))
+		return nil
+	}},
+	{OP_NOT, "Rd = ^Rs", []Form{
+		form("NOT", operand(OperandR, FieldRd), operand(OperandR, FieldRs)),
+	}, func(vm *VM, in Inst) error {
+		vm.Not(int(in.Rd), int(in.Rs))
+		return nil
+	}},
+	{OP_LSH, "Rd = Rs << Imm8", shift("LSH"), func(vm *VM, in Inst) error {
+		vm.Lsh(int(in.Rd), int(in.Rs), int(in.Imm))
+		return nil
+	}},
+	{OP_RSH, "Rd = Rs >> Imm8, logical", shift("RSH"), func(vm *VM, in Inst) error {
+		vm.Rsh(int(in.Rd), int(in.Rs), int(in.Imm))
+		return nil
+	}},
+	{OP_CSH, "Rd = Rs rotated left by Imm8", shift("CSH"), func(vm *VM, in Inst) error {
+		vm.Csh(int(in.Rd), int(in.Rs), int(in.Imm))
+		return nil
+	}},
+	{OP_SAR, "Rd = Rs >> Imm8, arithmetic", shift("SAR"), func(vm *VM, in Inst) error {
+		vm.Sar(int(in.Rd), int(in.Rs), int(in.Imm))
+		return nil
+	}},
+	{OP_LSHV, "Rd = Rs << Rt; 0 if Rt ≥ 256", shiftReg("LSH"), func(vm *VM, in Inst) error {
+		vm.Lsh(int(in.Rd), int(in.Rs), vm.shiftCount(int(in.Rt)))
+		return nil
+	}},
+	{OP_RSHV, "Rd = Rs >> Rt, logical; 0 if Rt ≥ 256", shiftReg("RSH"), func(vm *VM, in Inst) error {
+		vm.Rsh(int(in.Rd), int(in.Rs), vm.shiftCount(int(in.Rt)))
+		return nil
+	}},
+	{OP_SARV, "Rd = Rs >> Rt, arithmetic; the sign if Rt ≥ 256", shiftReg("SAR"), func(vm *VM, in Inst) error {
+		vm.Sar(int(in.Rd), int(in.Rs), vm.shiftCount(int(in.Rt)))
+		return nil
+	}},
+	{OP_ROL, "Rd = Rs rotated left by Imm8", shift("ROL"), func(vm *VM, in Inst) error {
+		vm.Rol(int(in.Rd), int(in.Rs), int(in.Imm))
+		return nil
+	}},
+	{OP_ROR, "Rd = Rs rotated right by Imm8", shift("ROR"), func(vm *VM, in Inst) error {
+		vm.Ror(int(in.Rd), int(in.Rs), int(in.Imm))
+		return nil
+	}},
+	{OP_ROLV, "Rd = Rs rotated left by Rt mod 256", shiftReg("ROL"), func(vm *VM, in Inst) error {
+		vm.Rol(int(in.Rd), int(in.Rs), int(low32(vm.R[in.Rt])%256))
+		return nil
+	}},
+	{OP_RORV, "Rd = Rs rotated right by Rt mod 256", shiftReg("ROR"), func(vm *VM, in Inst) error {
+		vm.Ror(int(in.Rd), int(in.Rs), int(low32(vm.R[in.Rt])%256))
+		return nil
+	}},
+
+	// Bit manipulation
+	{OP_POPCNT, "Rd = number of set bits in Rs", []Form{rr("POPCNT")}, func(vm *VM, in Inst) error {
+		vm.Popcnt(int(in.Rd), int(in.Rs))
+		return nil
+	}},
+	{OP_CLZ, "Rd = number of leading zero bits in Rs; 256 and sets CF if Rs is zero", []Form{rr("CLZ")}, func(vm *VM, in Inst) error {
+		vm.Clz(int(in.Rd), int(in.Rs))
+		return nil
+	}},
+	{OP_CTZ, "Rd = number of trailing zero bits in Rs; 256 and sets CF if Rs is zero", []Form{rr("CTZ")}, func(vm *VM, in Inst) error {
+		vm.Ctz(int(in.Rd), int(in.Rs))
+		return nil
+	}},
+	{OP_BSWAP, "Rd = Rs with its 32 bytes reversed", []Form{rr("BSWAP")}, func(vm *VM, in Inst) error {
+		vm.Bswap(int(in.Rd), int(in.Rs))
+		return nil
+	}},
+	{OP_BT, "CF = bit Rt of Rs", []Form{
+		form("BT", operand(OperandR, FieldRs), operand(OperandR, FieldRt)),
+	}, func(vm *VM, in Inst) error {
+		vm.Bt(int(in.Rs), int(low32(vm.R[in.Rt])))
+		return nil
+	}},
+	{OP_BTS, "CF = bit Rt of Rs; Rd = Rs with the bit set", shiftReg("BTS"), func(vm *VM, in Inst) error {
+		vm.Bts(int(in.Rd), int(in.Rs), int(low32(vm.R[in.Rt])))
+		return nil
+	}},
+	{OP_BTR, "CF = bit Rt of Rs; Rd = Rs with the bit cleared", shiftReg("BTR"), func(vm *VM, in Inst) error {
+		vm.Btr(int(in.Rd), int(in.Rs), int(low32(vm.R[in.Rt])))
+		return nil
+	}},
+	{OP_BTC, "CF = bit Rt of Rs; Rd = Rs with the bit inverted", shiftReg("BTC"), func(vm *VM, in Inst) error {
+		vm.Btc(int(in.Rd), int(in.Rs), int(low32(vm.R[in.Rt])))
+		return nil
+	}},
+	{OP_BTI, "CF = bit Imm8 of Rs", []Form{
+		form("BT", operand(OperandR, FieldRs), operand(OperandImm, FieldImm8)),
+	}, func(vm *VM, in Inst) error {
+		vm.Bt(int(in.Rs), int(in.Imm))
+		return nil
+	}},
+	{OP_BTSI, "CF = bit Imm8 of Rs; Rd = Rs with the bit set", shift("BTS"), func(vm *VM, in Inst) error {
+		vm.Bts(int(in.Rd), int(in.Rs), int(in.Imm))
+		return nil
+	}},
+	{OP_BTRI, "CF = bit Imm8 of Rs; Rd = Rs with the bit cleared", shift("BTR"), func(vm *VM, in Inst) error {
+		vm.Btr(int(in.Rd), int(in.Rs), int(in.Imm))
+		return nil
+	}},
+	{OP_BTCI, "CF = bit Imm8 of Rs; Rd = Rs with the bit inverted", shift("BTC"), func(vm *VM, in Inst) error {
+		vm.Btc(int(in.Rd), int(in.Rs), int(in.Imm))
+		return nil
+	}},
+
+	// Jumps
+	{OP_JMP, "Jump to Addr", jump("JMP"), func(vm *VM, in Inst) error {
+		vm.Jump(in.Addr)
+		return nil
+	}},
+	{OP_JZ, "Jump to Addr if ZF", jump("JZ"), func(vm *VM, in Inst) error {
+		vm.JumpIf(in.Addr, vm.GetFlag(ZF))
+		return nil
+	}},
+	{OP_JNZ, "Jump to Addr unless ZF", jump("JNZ"), func(vm *VM, in Inst) error {
+		vm.JumpIf(in.Addr, !vm.GetFlag(ZF))
+		return nil
+	}},
+	{OP_JGT, "Jump to Addr if GT", jump("JGT"), func(vm *VM, in Inst) error {
+		vm.JumpIf(in.Addr, vm.GetFlag(GT))
+		return nil
+	}},
+	{OP_JLT, "Jump to Addr if LT", jump("JLT"), func(vm *VM, in Inst) error {
+		vm.JumpIf(in.Addr, vm.GetFlag(LT))
+		return nil
+	}},
+	{OP_JEQ, "Jump to Addr if EQ", jump("JEQ"), func(vm *VM, in Inst) error {
+		vm.JumpIf(in.Addr, vm.GetFlag(EQ))
+		return nil
+	}},
+	{OP_JNE, "Jump to Addr unless EQ", jump("JNE"), func(vm *VM, in Inst) error {
+		vm.JumpIf(in.Addr, !vm.GetFlag(EQ))
+		return nil
+	}},
+	{OP_JGE, "Jump to Addr if GT or EQ", jump("JGE"), func(vm *VM, in Inst) error {
+		vm.JumpIf(in.Addr, vm.GetFlag(GT) || vm.GetFlag(EQ))
+		return nil
+	}},
+	{OP_JLE, "Jump to Addr if LT or EQ", jump("JLE"), func(vm *VM, in Inst) error {
+		vm.JumpIf(in.Addr, vm.GetFlag(LT) || vm.GetFlag(EQ))
+		return nil
+	}},
+	{OP_JMPA, "Jump to the address in As", []Form{
+		form("JMP", operand(OperandA, FieldRs)),
+	}, func(vm *VM, in Inst) error {
+		vm.Jump(vm.A[in.Rs])
+		return nil
+	}},
+	{OP_JMPJ, "Jump to the address in J, returning from the last jump", []Form{
+		form("JMP", fixed("J")),
+	}, func(vm *VM, in Inst) error {
+		vm.Jump(vm.J)
+		return nil
+	}},
+
+	// Relative branches
+	{OP_BRA, "Branch by Offset", branch("BRA"), func(vm *VM, in Inst) error {
+		vm.Branch(in.Offset, true)
+		return nil
+	}},
+	{OP_BZ, "Branch by Offset if ZF", branch("BZ"), func(vm *VM, in Inst) error {
+		vm.Branch(in.Offset, vm.GetFlag(ZF))
+		return nil
+	}},
+	{OP_BNZ, "Branch by Offset unless ZF", branch("BNZ"), func(vm *VM, in Inst) error {
+		vm.Branch(in.Offset, !vm.GetFlag(ZF))
+		return nil
+	}},
+	{OP_BEQ, "Branch by Offset if EQ", branch("BEQ"), func(vm *VM, in Inst) error {
+		vm.Branch(in.Offset, vm.GetFlag(EQ))
+		return nil
+	}},
+	{OP_BNE, "Branch by Offset unless EQ", branch("BNE"), func(vm *VM, in Inst) error {
+		vm.Branch(in.Offset, !vm.GetFlag(EQ))
+		return nil
+	}},
+	{OP_BGT, "Branch by Offset if GT", branch("BGT"), func(vm *VM, in Inst) error {
+		vm.Branch(in.Offset, vm.GetFlag(GT))
+		return nil
+	}},
+	{OP_BLT, "Branch by Offset if LT", branch("BLT"), func(vm *VM, in Inst) error {
+		vm.Branch(in.Offset, vm.GetFlag(LT))
+		return nil
+	}},
+	{OP_BGE, "Branch by Offset if GT or EQ", branch("BGE"), func(vm *VM, in Inst) error {
+		vm.Branch(in.Offset, vm.GetFlag(GT) || vm.GetFlag(EQ))
+		return nil
+	}},
+	{OP_BLE, "Branch by Offset if LT or EQ", branch("BLE"), func(vm *VM, in Inst) error {
+		vm.Branch(in.Offset, vm.GetFlag(LT) || vm.GetFlag(EQ))
+		return nil
+	}},
+
+	// Data movement
+	{OP_LDI, "Rd or Fd = Imm20", []Form{
+		form("LDI", operand(OperandR, FieldRd), operand(OperandImm, FieldImm20)),
+		form("LDI", operand(OperandF, FieldRd), operand(OperandImm, FieldImm20)),
+	}, func(vm *VM, in Inst) error {
+		vm.LoadImm(int(in.Rd), FieldImm20.Get(in.Word))
+		return nil
+	}},
+	{OP_LDA, "Ad = Imm20", []Form{
+		form("LDI", operand(OperandA, FieldRd), operand(OperandImm, FieldImm20)),
+	}, func(vm *VM, in Inst) error {
+		vm.LoadAddr(int(in.Rd), FieldImm20.Get(in.Word))
+		return nil
+	}},
+	{OP_LDW, "Rd = the N literal words that follow, most significant first", []Form{
+		form("LDW", operand(OperandR, FieldRd), operand(OperandCount, FieldImm3)),
+	}, func(vm *VM, in Inst) error {
+		n := int(FieldImm3.Get(in.Word)) + 1
+		if err := vm.LoadWide(int(in.Rd), n); err != nil {
+			return err
+		}
+		// Skip the literal words.
+		vm.PC += uint32(n) + 1
+		vm.jumped = true
+		return nil
+	}},
+	{OP_MOV, "Rd = Rs or Fd = Fs; between the files, the binary256 bits", []Form{
+		form("MOV", operand(OperandR, FieldRd), operand(OperandR, FieldRs)),
+		form("MOV", operand(OperandF, FieldRd), operand(OperandF, FieldRs)),
+		form("MOV", operand(OperandR, FieldRd), operand(OperandF, FieldRs)),
+		form("MOV", operand(OperandF, FieldRd), operand(OperandR, FieldRs)),
+	}, func(vm *VM, in Inst) error {
+		return vm.Move(int(in.Rd), int(in.Rs))
+	}},
+	{OP_RTOA, "Ad = the low 32 bits of Rs", []Form{
+		form("MOV", operand(OperandA, FieldRd), operand(OperandR, FieldRs)),
+	}, func(vm *VM, in Inst) error {
+		vm.MoveToAddr(int(in.Rd), int(in.Rs))
+		return nil
+	}},
+	{OP_ATOR, "Rd = As", []Form{
+		form("MOV", operand(OperandR, FieldRd), operand(OperandA, FieldRs)),
+	}, func(vm *VM, in Inst) error {
+		vm.MoveFromAddr(int(in.Rd), int(in.Rs))
+		return nil
+	}},
+	{OP_MOVA, "Ad = As", []Form{
+		form("MOV", operand(OperandA, FieldRd), operand(OperandA, FieldRs)),
+	}, func(vm *VM, in Inst) error {
+		vm.MoveAddr(int(in.Rd), int(in.Rs))
+		return nil
+	}},
+	{OP_ADDA, "Ad = As + Simm16, wrapping at 32 bits", []Form{
+		form("ADD", operand(OperandA, FieldRd), operand(OperandA, FieldRs), operand(OperandSimm, FieldImm16)),
+	}, func(vm *VM, in Inst) error {
+		vm.AddAddr(int(in.Rd), int(in.Rs), int32(int16(in.Word)))
+		return nil
+	}},
+	{OP_ADDAR, "Ad = As + the low 32 bits of Rt, wrapping at 32 bits", []Form{
+		form("ADD", operand(OperandA, FieldRd), operand(OperandA, FieldRs), operand(OperandR, FieldRt)),
+	}, func(vm *VM, in Inst) error {
+		vm.AddAddrReg(int(in.Rd), int(in.Rs), int(in.Rt))
+		return nil
+	}},
+
+	// Stack
+	{OP_PUSH, "Push Rs or Fs as 32 bytes", []Form{
+		form("PUSH", operand(OperandR, FieldRs)),
+		form("PUSH", operand(OperandF, FieldRs)),
+	}, func(vm *VM, in Inst) error {
+		return vm.Push(int(in.Rs))
+	}},
+	{OP_PUSHA, "Push As as 4 bytes", []Form{
+		form("PUSH", operand(OperandA, FieldRs)),
+	}, func(vm *VM, in Inst) error {
+		return vm.PushAddr(int(in.Rs))
+	}},
+	{OP_POP, "Pop 32 bytes into Rd or Fd", []Form{
+		form("POP", operand(OperandR, FieldRd)),
+		form("POP", operand(OperandF, FieldRd)),
+	}, func(vm *VM, in Inst) error {
+		return vm.Pop(int(in.Rd))
+	}},
+	{OP_POPA, "Pop 4 bytes into Ad", []Form{
+		form("POP", operand(OperandA, FieldRd)),
+	}, func(vm *VM, in Inst) error {
+		return vm.PopAddr(int(in.Rd))
+	}},
+	{OP_CALL, "Push the return address and jump to Addr", jump("CALL"), func(vm *VM, in Inst) error {
+		return vm.Call(in.Addr)
+	}},
+	{OP_RET, "Pop the return address and jump to it", []Form{form("RET")}, func(vm *VM, in Inst) error {
+		return vm.Ret()
+	}},
+	{OP_GETSP, "Ad = SP", []Form{
+		form("MOV", operand(OperandA, FieldRd), fixed("SP")),
+	}, func(vm *VM, in Inst) error {
+		vm.A[in.Rd] = vm.SP
+		return nil
+	}},
+	{OP_SETSP, "SP = As", []Form{
+		form("MOV", fixed("SP"), operand(OperandA, FieldRs)),
+	}, func(vm *VM, in Inst) error {
+		vm.SP = vm.A[in.Rs]
+		return nil
+	}},
+
+	// Floating-point control
+	{OP_GETFPCR, "Rd = FPCR", []Form{
+		form("MOV", operand(OperandR, FieldRd), fixed("FPCR")),
+	}, func(vm *VM, in Inst) error {
+		vm.R[in.Rd].SetUint64(uint64(vm.FPCR))
+		return nil
+	}},
+	{OP_SETFPCR, "FPCR = low 32 bits of Rs; faults if not a valid FPCR value or the precision exceeds 1024 bits", []Form{
+		form("MOV", fixed("FPCR"), operand(OperandR, FieldRs)),
+	}, func(vm *VM, in Inst) error {
+		return vm.SetFPCR(low32(vm.R[in.Rs]))
+	}},
+
+	// System
+	{OP_SYSCALL, "Call host function Imm16", []Form{
+		form("SYSCALL", operand(OperandImm, FieldImm16)),
+	}, func(vm *VM, in Inst) error {
+		return vm.Syscall(uint16(FieldImm16.Get(in.Word)))
+	}},
+	{OP_HALT, "Halt with exit code Imm8, or 0", []Form{
+		form("HALT"),
+		form("HALT", operand(OperandImm, FieldImm8)),
+	}, func(vm *VM, in Inst) error {
+		vm.Halt(int(in.Imm))
+		return nil
+	}},
+}
@@ -0,0 +1,58 @@
+package tmach
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestISA tests that the table defines the opcodes Execute accepts and that
+// the fields of every form fit in the instruction word without overlapping.
+func TestISA(t *testing.T) {
+	for opcode := uint32(0); opcode < 256; opcode++ {
+		var f *Fault
+		err := NewVM().Execute(opcode << 24)
+		unknown := errors.As(err, &f) && f.Kind == FaultOpcode
+		if unknown != (Lookup(opcode) == nil) {
+			t.Errorf("opcode 0x%02X: Execute returned %v, but Lookup returned %v", opcode, err, Lookup(opcode))
+		}
+	}
+
+	for _, op := range ISA {
+		if len(op.Forms) == 0 || op.Summary == "" {
+			t.Errorf("opcode 0x%02X: expected forms and a summary", op.Opcode)
+		}
+		for _, form := range op.Forms {
+			used := FieldOpcode.Mask() << FieldOpcode.Shift
+			for _, o := range form.Operands {
+				for _, fl := range o.Fields {
+					if used&(fl.Mask()<<fl.Shift) != 0 || fl.Shift+fl.Width > 24 {
+						t.Errorf("%s: field %s overlaps another field", form, fl.Name)
+					}
+					used |= fl.Mask() << fl.Shift
+				}
+			}
+		}
+	}
+
+	if s := Lookup(OP_LOAD).Forms[0].Encoding(); s != "Opcode (8) | Rd (4) | Reserved (8) | Ax (4) | Reserved (8)" {
+		t.Errorf("Encoding failed: unexpected LOAD layout %q", s)
+	}
+	if s := Lookup(OP_LDW).Forms[0].String(); s != "LDW Rd, N" {
+		t.Errorf("String failed: expected %q, got %q", "LDW Rd, N", s)
+	}
+}
+
+// TestDecode tests that Decode extracts the fields described by the table.
+func TestDecode(t *testing.T) {
+	for _, word := range []uint32{0, 0x12345678, 0xFFFFFFFF, 0x3C800001} {
+		in := Decode(word)
+		if in.Opcode != FieldOpcode.Get(word) || in.Rd != FieldRd.Get(word) || in.Rs != FieldRs.Get(word) ||
+			in.Rt != FieldRt.Get(word) || in.Ax != FieldAx.Get(word) || in.Imm != FieldImm8.Get(word) ||
+			in.Addr != FieldAddr.Get(word) || uint32(in.Offset)&0xFFFFFF != in.Addr {
+			t.Errorf("Decode failed: %08X decoded as %+v", word, in)
+		}
+	}
+	if in := Decode(0x34FFFFFE); in.Offset != -2 {
+		t.Errorf("Decode failed: expected offset -2, got %d", in.Offset)
+	}
+}
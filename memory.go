@@ -0,0 +1,108 @@
+package tmach
+
+import "sort"
+
+// DefaultMemorySize is the size of the memory created by NewVM (64 MB).
+const DefaultMemorySize = 64 * 1024 * 1024
+
+// PageSize is the allocation unit of SparseMemory.
+const PageSize = 4096
+
+// Memory is the guest address space of a virtual machine.
+// The VM checks bounds against Size before every access, so ReadBytes and
+// WriteBytes are only called with ranges inside [0, Size()).
+type Memory interface {
+	// Size returns the size of the address space in bytes.
+	Size() uint32
+	// ReadBytes copies len(p) bytes starting at addr into p.
+	ReadBytes(addr uint32, p []byte)
+	// WriteBytes copies p into memory starting at addr.
+	WriteBytes(addr uint32, p []byte)
+}
+
+// FlatMemory is a Memory backed by a contiguous byte slice.
+type FlatMemory []byte
+
+// Size returns the length of the slice.
+func (m FlatMemory) Size() uint32 { return uint32(len(m)) }
+
+// ReadBytes copies len(p) bytes starting at addr into p.
+func (m FlatMemory) ReadBytes(addr uint32, p []byte) { copy(p, m[addr:]) }
+
+// WriteBytes copies p into memory starting at addr.
+func (m FlatMemory) WriteBytes(addr uint32, p []byte) { copy(m[addr:], p) }
+
+// SparseMemory is a Memory that allocates PageSize pages on first write.
+// Pages that were never written read as zero and cost nothing, so a large
+// address space is cheap for programs that touch little of it.
+type SparseMemory struct {
+	size  uint32
+	pages map[uint32]*[PageSize]byte // keyed by page number
+}
+
+// NewSparseMemory returns an empty sparse memory of the given size in bytes.
+func NewSparseMemory(size uint32) *SparseMemory {
+	return &SparseMemory{size: size, pages: make(map[uint32]*[PageSize]byte)}
+}
+
+// Size returns the size of the address space in bytes.
+func (m *SparseMemory) Size() uint32 { return m.size }
+
+// ReadBytes copies len(p) bytes starting at addr into p.
+func (m *SparseMemory) ReadBytes(addr uint32, p []byte) {
+	for len(p) > 0 {
+		off := addr % PageSize
+		n := min(len(p), PageSize-int(off))
+		if page := m.pages[addr/PageSize]; page != nil {
+			copy(p[:n], page[off:])
+		} else {
+			clear(p[:n])
+		}
+		p = p[n:]
+		addr += uint32(n)
+	}
+}
+
+// WriteBytes copies p into memory starting at addr. Writing zeros to a page
+// that was never written does not allocate it.
+func (m *SparseMemory) WriteBytes(addr uint32, p []byte) {
+	for len(p) > 0 {
+		off := addr % PageSize
+		n := min(len(p), PageSize-int(off))
+		page := m.pages[addr/PageSize]
+		if page == nil && !isZero(p[:n]) {
+			page = new([PageSize]byte)
+			m.pages[addr/PageSize] = page
+		}
+		if page != nil {
+			copy(page[off:], p[:n])
+		}
+		p = p[n:]
+		addr += uint32(n)
+	}
+}
+
+// Pages calls fn for each allocated page in ascending address order, with the
+// address of the page and its contents, until fn returns false.
+func (m *SparseMemory) Pages(fn func(addr uint32, page []byte) bool) {
+	keys := make([]uint32, 0, len(m.pages))
+	for k := range m.pages {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, k := range keys {
+		if !fn(k*PageSize, m.pages[k][:]) {
+			return
+		}
+	}
+}
+
+// isZero reports whether all bytes of p are zero.
+func isZero(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package tmach
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+// TestSparseMemory tests reads and writes across page boundaries.
+func TestSparseMemory(t *testing.T) {
+	mem := NewSparseMemory(4 * PageSize)
+
+	data := bytes.Repeat([]byte{0xAB}, PageSize+64)
+	mem.WriteBytes(PageSize-32, data)
+
+	got := make([]byte, len(data)+64)
+	mem.ReadBytes(PageSize-64, got)
+	if !bytes.Equal(got[32:32+len(data)], data) {
+		t.Error("read back does not match write")
+	}
+	if !isZero(got[:32]) || !isZero(got[32+len(data):]) {
+		t.Error("bytes outside the write are not zero")
+	}
+
+	var pages []uint32
+	mem.Pages(func(addr uint32, page []byte) bool {
+		pages = append(pages, addr)
+		return true
+	})
+	if len(pages) != 3 || pages[0] != 0 || pages[2] != 2*PageSize {
+		t.Errorf("unexpected allocated pages %#x", pages)
+	}
+
+	// Writing zeros to untouched pages does not allocate them.
+	mem.WriteBytes(3*PageSize, make([]byte, PageSize))
+	if len(mem.pages) != 3 {
+		t.Errorf("zero write allocated a page, %d pages", len(mem.pages))
+	}
+}
+
+// TestMemoryImplementations tests that LOAD/STORE behave the same on every Memory.
+func TestMemoryImplementations(t *testing.T) {
+	for _, mem := range []Memory{NewSparseMemory(1 << 20), make(FlatMemory, 1<<20)} {
+		vm := NewVMWithMemory(mem)
+		if vm.StackBase != 1<<20 || vm.SP != vm.StackBase {
+			t.Errorf("%T: stack not at top of memory, base %#x", mem, vm.StackBase)
+		}
+
+		vm.A[0] = PageSize - 16 // straddles a page boundary
+		vm.R[0].SetInt64(123456789)
+		vm.Store(0, 0)
+		vm.Load(1, 0)
+		if vm.R[1].Cmp(big.NewInt(123456789)) != 0 {
+			t.Errorf("%T: LOAD/STORE failed, got %v", mem, vm.R[1])
+		}
+
+		vm.A[0] = mem.Size() - 31
+		if f, ok := vm.Load(1, 0).(*Fault); !ok || f.Kind != FaultMemory {
+			t.Errorf("%T: expected memory fault, got %v", mem, f)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Package obj implements the tmach object file format, a portable container
+// for assembled programs, and a loader that maps it into a virtual machine.
+//
+// All integers are big-endian. A file starts with a fixed header:
+//
+//	Magic       [4]byte  "TMCH"
+//	Version     uint16   currently 1
+//	Flags       uint16   bit 0: debug info present
+//	Entry       uint32   instruction address where execution starts
+//	NumSections uint16
+//	NumSymbols  uint16
+//
+// followed by the sections, the symbol table and, if flagged, the debug info:
+//
+//	Section: Kind uint8, Name string8, Addr uint32, Size uint32, Data [Size]byte
+//	Symbol:  Kind uint8, Name string8, Value uint32
+//	Debug:   Source string16, NumLines uint32, NumLines × (PC uint32, Line uint32)
+//
+// where stringN is a uintN length followed by that many bytes. Section
+// addresses are byte addresses; code symbol values are instruction addresses
+// and data symbol values byte addresses.
+package obj
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/xtaci/tmach"
+)
+
+// Magic identifies a tmach object file.
+const Magic = "TMCH"
+
+// Version is the format version written by this package.
+const Version = 1
+
+const flagDebug = 1 << 0
+
+// ErrFormat is returned when reading a malformed object file.
+var ErrFormat = errors.New("obj: invalid object file")
+
+// Kind is the kind of a section or symbol.
+type Kind uint8
+
+const (
+	Code Kind = iota + 1 // Instructions
+	Data                 // Data
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Code:
+		return "code"
+	case Data:
+		return "data"
+	}
+	return fmt.Sprintf("kind %d", uint8(k))
+}
+
+// Section is a contiguous block of memory contents.
+type Section struct {
+	Name string
+	Kind Kind
+	Addr uint32 // Byte address the section is loaded at
+	Data []byte
+}
+
+// Symbol names an address in the program.
+type Symbol struct {
+	Name  string
+	Kind  Kind   // Code symbols name instructions, data symbols bytes
+	Value uint32 // Instruction address for Code, byte address for Data
+}
+
+// Line maps an instruction address to a source line.
+type Line struct {
+	PC   uint32
+	Line uint32
+}
+
+// Debug holds optional source-level debug information.
+type Debug struct {
+	Source string // Source file name
+	Lines  []Line // Sorted by PC
+}
+
+// File is a tmach object file.
+type File struct {
+	Entry    uint32 // Instruction address where execution starts
+	Sections []Section
+	Symbols  []Symbol
+	Debug    *Debug // Optional
+}
+
+// Lookup returns the symbol with the given name.
+func (f *File) Lookup(name string) (Symbol, bool) {
+	for _, s := range f.Symbols {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Symbol{}, false
+}
+
+// Section returns the first section of the given kind, or nil.
+func (f *File) Section(kind Kind) *Section {
+	for i := range f.Sections {
+		if f.Sections[i].Kind == kind {
+			return &f.Sections[i]
+		}
+	}
+	return nil
+}
+
+// Load copies the sections into vm's memory and sets PC to the entry point.
+func (f *File) Load(vm *tmach.VM) error {
+	for _, s := range f.Sections {
+		if uint64(s.Addr)+uint64(len(s.Data)) > uint64(vm.Memory.Size()) {
+			return fmt.Errorf("obj: section %s at 0x%X does not fit in memory", s.Name, s.Addr)
+		}
+	}
+	for _, s := range f.Sections {
+		vm.Memory.WriteBytes(s.Addr, s.Data)
+	}
+	vm.PC = f.Entry
+	return nil
+}
+
+// WriteTo writes the object file to w. It fails, writing nothing, if the
+// file has more than 65535 sections or symbols, a section or symbol name
+// longer than 255 bytes, or a source name longer than 65535 bytes.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	if len(f.Sections) > math.MaxUint16 || len(f.Symbols) > math.MaxUint16 {
+		return 0, errors.New("obj: too many sections or symbols")
+	}
+	for _, s := range f.Sections {
+		if len(s.Name) > math.MaxUint8 {
+			return 0, fmt.Errorf("obj: section name %q is longer than %d bytes", s.Name, math.MaxUint8)
+		}
+	}
+	for _, s := range f.Symbols {
+		if len(s.Name) > math.MaxUint8 {
+			return 0, fmt.Errorf("obj: symbol name %q is longer than %d bytes", s.Name, math.MaxUint8)
+		}
+	}
+	if f.Debug != nil && len(f.Debug.Source) > math.MaxUint16 {
+		return 0, fmt.Errorf("obj: source name is longer than %d bytes", math.MaxUint16)
+	}
+	e := &encoder{}
+	var flags uint16
+	if f.Debug != nil {
+		flags |= flagDebug
+	}
+	e.buf = append(e.buf, Magic...)
+	e.u16(Version)
+	e.u16(flags)
+	e.u32(f.Entry)
+	e.u16(uint16(len(f.Sections)))
+	e.u16(uint16(len(f.Symbols)))
+	for _, s := range f.Sections {
+		e.buf = append(e.buf, byte(s.Kind))
+		e.str8(s.Name)
+		e.u32(s.Addr)
+		e.u32(uint32(len(s.Data)))
+		e.buf = append(e.buf, s.Data...)
+	}
+	for _, s := range f.Symbols {
+		e.buf = append(e.buf, byte(s.Kind))
+		e.str8(s.Name)
+		e.u32(s.Value)
+	}
+	if f.Debug != nil {
+		e.u16(uint16(len(f.Debug.Source)))
+		e.buf = append(e.buf, f.Debug.Source...)
+		e.u32(uint32(len(f.Debug.Lines)))
+		for _, l := range f.Debug.Lines {
+			e.u32(l.PC)
+			e.u32(l.Line)
+		}
+	}
+	n, err := w.Write(e.buf)
+	return int64(n), err
+}
+
+type encoder struct{ buf []byte }
+
+func (e *encoder) u16(v uint16) { e.buf = binary.BigEndian.AppendUint16(e.buf, v) }
+func (e *encoder) u32(v uint32) { e.buf = binary.BigEndian.AppendUint32(e.buf, v) }
+
+func (e *encoder) str8(s string) {
+	e.buf = append(e.buf, byte(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// Read reads an object file from r.
+func Read(r io.Reader) (*File, error) {
+	d := &decoder{r: bufio.NewReader(r)}
+	var magic [4]byte
+	d.read(magic[:])
+	if d.err == nil && string(magic[:]) != Magic {
+		return nil, ErrFormat
+	}
+	version := d.u16()
+	if d.err == nil && version != Version {
+		return nil, fmt.Errorf("obj: unsupported version %d", version)
+	}
+	flags := d.u16()
+	f := &File{Entry: d.u32()}
+	nsec, nsym := d.u16(), d.u16()
+	for i := 0; i < int(nsec) && d.err == nil; i++ {
+		s := Section{Kind: Kind(d.u8()), Name: d.str(int(d.u8()))}
+		s.Addr = d.u32()
+		s.Data = d.bytes(d.u32())
+		f.Sections = append(f.Sections, s)
+	}
+	for i := 0; i < int(nsym) && d.err == nil; i++ {
+		s := Symbol{Kind: Kind(d.u8()), Name: d.str(int(d.u8()))}
+		s.Value = d.u32()
+		f.Symbols = append(f.Symbols, s)
+	}
+	if flags&flagDebug != 0 {
+		dbg := &Debug{Source: d.str(int(d.u16()))}
+		n := d.u32()
+		for i := uint32(0); i < n && d.err == nil; i++ {
+			dbg.Lines = append(dbg.Lines, Line{PC: d.u32(), Line: d.u32()})
+		}
+		f.Debug = dbg
+	}
+	if d.err != nil {
+		if errors.Is(d.err, io.EOF) || errors.Is(d.err, io.ErrUnexpectedEOF) {
+			return nil, ErrFormat
+		}
+		return nil, d.err
+	}
+	return f, nil
+}
+
+// Open reads the object file with the given name.
+func Open(name string) (*File, error) {
+	fd, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	f, err := Read(fd)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return f, nil
+}
+
+// decoder reads big-endian values, remembering the first error.
+type decoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (d *decoder) read(p []byte) {
+	if d.err == nil {
+		_, d.err = io.ReadFull(d.r, p)
+	}
+}
+
+func (d *decoder) u8() uint8 {
+	var b [1]byte
+	d.read(b[:])
+	return b[0]
+}
+
+func (d *decoder) u16() uint16 {
+	var b [2]byte
+	d.read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
+
+func (d *decoder) u32() uint32 {
+	var b [4]byte
+	d.read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
+
+func (d *decoder) str(n int) string {
+	return string(d.bytes(uint32(n)))
+}
+
+// bytes reads n bytes without trusting n for the allocation size.
+func (d *decoder) bytes(n uint32) []byte {
+	if d.err != nil {
+		return nil
+	}
+	var buf []byte
+	_, d.err = io.CopyN(&sliceWriter{&buf}, d.r, int64(n))
+	return buf
+}
+
+type sliceWriter struct{ buf *[]byte }
+
+func (w *sliceWriter) Write(p []byte) (int, error) {
+	*w.buf = append(*w.buf, p...)
+	return len(p), nil
+}
@@ -0,0 +1,114 @@
+package obj
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xtaci/tmach"
+)
+
+func testFile() *File {
+	return &File{
+		Entry: 2,
+		Sections: []Section{
+			{Name: ".text", Kind: Code, Addr: 0, Data: []byte{0x18, 0x00, 0x00, 0x07, 0, 0, 0, 0, 0x18, 0x10, 0x00, 0x09}},
+			{Name: ".data", Kind: Data, Addr: 0x100, Data: []byte{1, 2, 3}},
+		},
+		Symbols: []Symbol{{Name: "start", Kind: Code, Value: 2}, {Name: "buf", Kind: Data, Value: 0x100}},
+		Debug:   &Debug{Source: "test.s", Lines: []Line{{0, 1}, {2, 3}}},
+	}
+}
+
+// TestRoundTrip tests that a file reads back as written.
+func TestRoundTrip(t *testing.T) {
+	for _, f := range []*File{testFile(), {Entry: 7}} {
+		var buf bytes.Buffer
+		if _, err := f.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		got, err := Read(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, f) {
+			t.Errorf("expected %+v, got %+v", f, got)
+		}
+	}
+
+	// Names at the length limits round-trip; longer ones are rejected
+	// rather than truncated.
+	for _, tt := range []struct {
+		name  string
+		edit  func(f *File, n int)
+		limit int
+	}{
+		{"section", func(f *File, n int) { f.Sections[0].Name = strings.Repeat("s", n) }, 255},
+		{"symbol", func(f *File, n int) { f.Symbols[1].Name = strings.Repeat("x", n) }, 255},
+		{"source", func(f *File, n int) { f.Debug.Source = strings.Repeat("y", n) }, 65535},
+	} {
+		f := testFile()
+		tt.edit(f, tt.limit)
+		var buf bytes.Buffer
+		if _, err := f.WriteTo(&buf); err != nil {
+			t.Errorf("%s name of %d bytes: %v", tt.name, tt.limit, err)
+		} else if got, err := Read(&buf); err != nil || !reflect.DeepEqual(got, f) {
+			t.Errorf("%s name of %d bytes: expected it to round-trip, got %v", tt.name, tt.limit, err)
+		}
+		tt.edit(f, tt.limit+1)
+		buf.Reset()
+		if _, err := f.WriteTo(&buf); err == nil || buf.Len() != 0 {
+			t.Errorf("%s name of %d bytes: expected an error and no output, got %v", tt.name, tt.limit+1, err)
+		}
+	}
+}
+
+// TestReadErrors tests that malformed files are rejected.
+func TestReadErrors(t *testing.T) {
+	var buf bytes.Buffer
+	testFile().WriteTo(&buf)
+	good := buf.Bytes()
+
+	if _, err := Read(bytes.NewReader([]byte("ELF\x7f..."))); !errors.Is(err, ErrFormat) {
+		t.Errorf("bad magic: expected ErrFormat, got %v", err)
+	}
+	for n := 0; n < len(good); n++ {
+		if _, err := Read(bytes.NewReader(good[:n])); !errors.Is(err, ErrFormat) {
+			t.Errorf("truncated to %d bytes: expected ErrFormat, got %v", n, err)
+		}
+	}
+	future := append([]byte(nil), good...)
+	future[5] = Version + 1
+	if _, err := Read(bytes.NewReader(future)); err == nil {
+		t.Error("expected an error for an unsupported version")
+	}
+}
+
+// TestLoad tests mapping a file into a VM and running it.
+func TestLoad(t *testing.T) {
+	vm := tmach.NewVM()
+	if err := testFile().Load(vm); err != nil {
+		t.Fatal(err)
+	}
+	if vm.PC != 2 {
+		t.Errorf("expected PC = 2, got %d", vm.PC)
+	}
+	var b [3]byte
+	vm.Memory.ReadBytes(0x100, b[:])
+	if b != [3]byte{1, 2, 3} {
+		t.Errorf("data section not loaded: %v", b)
+	}
+	if err := vm.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if vm.R[1].Int64() != 9 || vm.R[0].Sign() != 0 {
+		t.Errorf("expected execution from the entry point, got R0 = %v, R1 = %v", vm.R[0], vm.R[1])
+	}
+
+	small := tmach.NewVMWithMemory(tmach.NewSparseMemory(0x80))
+	if err := testFile().Load(small); err == nil {
+		t.Error("expected an error loading past the end of memory")
+	}
+}
@@ -0,0 +1,393 @@
+package tmach
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+)
+
+// Snapshot is the state of a virtual machine: its registers, gas and the
+// pages of memory that hold non-zero bytes. Host configuration (Costs,
+// FaultHandler, Hook, system calls and streams) is not part of a snapshot.
+type Snapshot struct {
+	R          [8]*big.Int
+	F          [8]*big.Float
+	A          [8]uint32
+	SR         byte
+	FPCR       uint32
+	PC, J, SP  uint32
+	StackBase  uint32
+	StackLimit uint32
+	Gas        uint64
+	State      State
+	ExitCode   int
+	MemorySize uint32
+	Pages      []Page // Non-zero pages in ascending address order
+}
+
+// Page is a PageSize block of memory in a Snapshot.
+type Page struct {
+	Addr uint32 // Multiple of PageSize
+	Data []byte // PageSize bytes, or fewer for the last page of memory
+}
+
+// Snapshot returns a copy of the state of vm.
+func (vm *VM) Snapshot() *Snapshot {
+	s := &Snapshot{
+		A:          vm.A,
+		SR:         vm.SR,
+		FPCR:       vm.FPCR,
+		PC:         vm.PC,
+		J:          vm.J,
+		SP:         vm.SP,
+		StackBase:  vm.StackBase,
+		StackLimit: vm.StackLimit,
+		Gas:        vm.Gas,
+		State:      vm.State,
+		ExitCode:   vm.ExitCode,
+		MemorySize: vm.Memory.Size(),
+	}
+	for i := range vm.R {
+		s.R[i] = new(big.Int).Set(vm.R[i])
+		s.F[i] = new(big.Float).Copy(vm.F[i])
+	}
+	keep := func(addr uint32, data []byte) bool {
+		if !isZero(data) {
+			s.Pages = append(s.Pages, Page{addr, append([]byte(nil), data...)})
+		}
+		return true
+	}
+	if m, ok := vm.Memory.(*SparseMemory); ok {
+		m.Pages(keep)
+	} else {
+		buf := make([]byte, PageSize)
+		for addr := uint64(0); addr < uint64(s.MemorySize); addr += PageSize {
+			p := buf[:min(PageSize, uint64(s.MemorySize)-addr)]
+			vm.Memory.ReadBytes(uint32(addr), p)
+			keep(uint32(addr), p)
+		}
+	}
+	return s
+}
+
+// Restore sets the state of vm to s. The memory of vm must have the size
+// recorded in s; bytes outside the pages of s are cleared. Every register of
+// s must be set, R registers must hold 256-bit words and F registers may
+// have at most MaxFloatPrec bits of precision.
+func (vm *VM) Restore(s *Snapshot) error {
+	if err := s.checkRegisters(); err != nil {
+		return err
+	}
+	if !validFPCR(s.FPCR) {
+		return fmt.Errorf("tmach: snapshot FPCR 0x%08X is invalid", s.FPCR)
+	}
+	if s.MemorySize != vm.Memory.Size() {
+		return fmt.Errorf("tmach: snapshot of %d bytes of memory does not match memory of %d bytes", s.MemorySize, vm.Memory.Size())
+	}
+	for _, p := range s.Pages {
+		if p.Addr%PageSize != 0 || len(p.Data) > PageSize || uint64(p.Addr)+uint64(len(p.Data)) > uint64(s.MemorySize) {
+			return fmt.Errorf("tmach: snapshot page at 0x%X is outside memory", p.Addr)
+		}
+	}
+
+	if m, ok := vm.Memory.(*SparseMemory); ok {
+		clear(m.pages)
+	} else {
+		zero := make([]byte, PageSize)
+		for addr := uint64(0); addr < uint64(s.MemorySize); addr += PageSize {
+			vm.Memory.WriteBytes(uint32(addr), zero[:min(PageSize, uint64(s.MemorySize)-addr)])
+		}
+	}
+	for _, p := range s.Pages {
+		vm.Memory.WriteBytes(p.Addr, p.Data)
+	}
+
+	for i := range vm.R {
+		vm.R[i].Set(s.R[i])
+		vm.F[i].Copy(s.F[i])
+	}
+	vm.A, vm.SR, vm.FPCR = s.A, s.SR, s.FPCR
+	vm.PC, vm.J, vm.SP = s.PC, s.J, s.SP
+	vm.StackBase, vm.StackLimit = s.StackBase, s.StackLimit
+	vm.Gas = s.Gas
+	vm.State, vm.ExitCode = s.State, s.ExitCode
+	vm.jumped = false
+	return nil
+}
+
+// checkRegisters reports an R or F register of s that a VM cannot hold.
+func (s *Snapshot) checkRegisters() error {
+	for i := range s.R {
+		if r := s.R[i]; r == nil || r.Sign() < 0 || r.BitLen() > 256 {
+			return fmt.Errorf("tmach: snapshot R%d is not a 256-bit word", i)
+		}
+		if f := s.F[i]; f == nil || f.Prec() > MaxFloatPrec {
+			return fmt.Errorf("tmach: snapshot F%d is missing or exceeds %d bits of precision", i, MaxFloatPrec)
+		}
+	}
+	return nil
+}
+
+// ===================================================================
+// Snapshot Encoding
+// ===================================================================
+
+// The snapshot encoding is big-endian:
+//
+//	Magic      [4]byte  "TMSS"
+//	Version    uint16   currently 1
+//	R0-R7      8 × [32]byte, unsigned 256-bit words
+//	F0-F7      8 × Float
+//	A0-A7      8 × uint32
+//	SR         uint8
+//	FPCR       uint32
+//	PC, J, SP, StackBase, StackLimit  uint32
+//	Gas        uint64
+//	State      uint8
+//	ExitCode   int64
+//	MemorySize uint32
+//	NumPages   uint32
+//	Pages      NumPages × (Addr uint32, Size uint16, Data [Size]byte)
+//
+// A Float is stored exactly, whatever its precision, as its value
+// Mant × 2^Exp:
+//
+//	Prec uint32, Mode uint8, Form uint8 (0 zero, 1 finite, 2 infinite),
+//	Neg uint8, Exp int64, MantLen uint16, Mant [MantLen]byte
+//
+// Binary traces use the same Float encoding, through AppendFloat and
+// ReadFloat.
+const (
+	snapshotMagic   = "TMSS"
+	snapshotVersion = 1
+)
+
+// ErrSnapshot is returned when reading a malformed snapshot.
+var ErrSnapshot = errors.New("tmach: invalid snapshot")
+
+// ErrFloatEncoding is returned by ReadFloat for a malformed Float.
+var ErrFloatEncoding = errors.New("tmach: invalid float encoding")
+
+// WriteTo writes the encoding of s to w. It fails, as Restore does, for a
+// register a VM cannot hold.
+func (s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	if err := s.checkRegisters(); err != nil {
+		return 0, err
+	}
+	b := []byte(snapshotMagic)
+	b = binary.BigEndian.AppendUint16(b, snapshotVersion)
+	for _, r := range s.R {
+		var word [32]byte
+		unsigned(r).FillBytes(word[:])
+		b = append(b, word[:]...)
+	}
+	for _, f := range s.F {
+		b = AppendFloat(b, f)
+	}
+	for _, a := range s.A {
+		b = binary.BigEndian.AppendUint32(b, a)
+	}
+	b = append(b, s.SR)
+	b = binary.BigEndian.AppendUint32(b, s.FPCR)
+	for _, v := range []uint32{s.PC, s.J, s.SP, s.StackBase, s.StackLimit} {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	b = binary.BigEndian.AppendUint64(b, s.Gas)
+	b = append(b, byte(s.State))
+	b = binary.BigEndian.AppendUint64(b, uint64(int64(s.ExitCode)))
+	b = binary.BigEndian.AppendUint32(b, s.MemorySize)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s.Pages)))
+	for _, p := range s.Pages {
+		b = binary.BigEndian.AppendUint32(b, p.Addr)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(p.Data)))
+		b = append(b, p.Data...)
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// AppendFloat appends the exact encoding of x, as stored in snapshots, to b
+// and returns the extended buffer. x must have a precision of at most
+// 8 × math.MaxUint16 bits.
+func AppendFloat(b []byte, x *big.Float) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(x.Prec()))
+	b = append(b, byte(x.Mode()))
+	var form, neg byte
+	var exp int64
+	var mant []byte
+	switch {
+	case x.IsInf():
+		form = 2
+	case x.Sign() != 0:
+		form = 1
+		// x = m × 2^e with m in [0.5, 1); scaling m by 2^prec makes it an
+		// integer, since it has at most prec bits.
+		m := new(big.Float)
+		e := x.MantExp(m)
+		m.SetMantExp(m, int(x.Prec()))
+		mi, _ := m.Int(nil)
+		mant = mi.Abs(mi).Bytes()
+		exp = int64(e) - int64(x.Prec())
+	}
+	if x.Signbit() {
+		neg = 1
+	}
+	b = append(b, form, neg)
+	b = binary.BigEndian.AppendUint64(b, uint64(exp))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(mant)))
+	return append(b, mant...)
+}
+
+// ReadSnapshot reads a snapshot encoded by Snapshot.WriteTo from r.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	d := &snapshotDecoder{r: bufio.NewReader(r)}
+	var magic [4]byte
+	d.read(magic[:])
+	if d.err == nil && string(magic[:]) != snapshotMagic {
+		return nil, ErrSnapshot
+	}
+	version := d.u16()
+	if d.err == nil && version != snapshotVersion {
+		return nil, fmt.Errorf("tmach: unsupported snapshot version %d", version)
+	}
+
+	s := &Snapshot{}
+	for i := range s.R {
+		var word [32]byte
+		d.read(word[:])
+		s.R[i] = new(big.Int).SetBytes(word[:])
+	}
+	for i := range s.F {
+		s.F[i] = d.float()
+		if d.err == nil && s.F[i].Prec() > MaxFloatPrec {
+			d.err = ErrSnapshot
+		}
+	}
+	for i := range s.A {
+		s.A[i] = d.u32()
+	}
+	s.SR = d.u8()
+	s.FPCR = d.u32()
+	if d.err == nil && !validFPCR(s.FPCR) {
+		d.err = ErrSnapshot
+	}
+	s.PC, s.J, s.SP = d.u32(), d.u32(), d.u32()
+	s.StackBase, s.StackLimit = d.u32(), d.u32()
+	s.Gas = d.u64()
+	s.State = State(d.u8())
+	s.ExitCode = int(int64(d.u64()))
+	if d.err == nil && s.State > Waiting {
+		d.err = ErrSnapshot
+	}
+	s.MemorySize = d.u32()
+	n := d.u32()
+	last := int64(-1)
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		p := Page{Addr: d.u32()}
+		size := d.u16()
+		if d.err == nil && (int64(p.Addr) <= last || p.Addr%PageSize != 0 || size > PageSize ||
+			uint64(p.Addr)+uint64(size) > uint64(s.MemorySize)) {
+			d.err = ErrSnapshot
+		}
+		p.Data = make([]byte, size)
+		d.read(p.Data)
+		s.Pages = append(s.Pages, p)
+		last = int64(p.Addr)
+	}
+	if d.err != nil {
+		if errors.Is(d.err, io.EOF) || errors.Is(d.err, io.ErrUnexpectedEOF) {
+			return nil, ErrSnapshot
+		}
+		return nil, d.err
+	}
+	return s, nil
+}
+
+// snapshotDecoder reads big-endian values, remembering the first error.
+type snapshotDecoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (d *snapshotDecoder) read(p []byte) {
+	if d.err == nil {
+		_, d.err = io.ReadFull(d.r, p)
+	}
+}
+
+func (d *snapshotDecoder) u8() uint8 {
+	var b [1]byte
+	d.read(b[:])
+	return b[0]
+}
+
+func (d *snapshotDecoder) u16() uint16 {
+	var b [2]byte
+	d.read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
+
+func (d *snapshotDecoder) u32() uint32 {
+	var b [4]byte
+	d.read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
+
+func (d *snapshotDecoder) u64() uint64 {
+	var b [8]byte
+	d.read(b[:])
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// float reads a Float written by AppendFloat.
+func (d *snapshotDecoder) float() *big.Float {
+	if d.err != nil {
+		return nil
+	}
+	x, err := ReadFloat(d.r)
+	if err == ErrFloatEncoding {
+		err = ErrSnapshot
+	}
+	d.err = err
+	return x
+}
+
+// ReadFloat reads a Float written by AppendFloat from r. It returns
+// ErrFloatEncoding if the encoding is malformed.
+func ReadFloat(r io.Reader) (*big.Float, error) {
+	var h [17]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return nil, err
+	}
+	prec, mode := binary.BigEndian.Uint32(h[0:]), big.RoundingMode(h[4])
+	form, neg := h[5], h[6]
+	exp := int64(binary.BigEndian.Uint64(h[7:]))
+	mant := make([]byte, binary.BigEndian.Uint16(h[15:]))
+	if _, err := io.ReadFull(r, mant); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if prec == 0 || prec > big.MaxPrec || mode > big.ToPositiveInf || form > 2 || neg > 1 ||
+		exp < math.MinInt32-int64(prec) || exp > math.MaxInt32 ||
+		(form == 1) != (len(mant) > 0) || (form == 1 && len(mant)*8 > int(prec)+8) {
+		return nil, ErrFloatEncoding
+	}
+	x := new(big.Float).SetPrec(uint(prec)).SetMode(mode)
+	switch form {
+	case 1:
+		x.SetInt(new(big.Int).SetBytes(mant))
+		x.SetMantExp(x, int(exp))
+	case 2:
+		x.SetInf(false)
+	}
+	if neg == 1 {
+		x.Neg(x)
+	}
+	return x, nil
+}
@@ -0,0 +1,157 @@
+package tmach
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"testing"
+)
+
+// snapshotProgram sums R1 down to zero into R0, pushing each partial sum.
+var snapshotProgram = []uint32{
+	OP_ADD<<24 | 0<<20 | 0<<16 | 1<<12, // ADD R0, R0, R1
+	OP_PUSH<<24 | 0<<16,                // PUSH R0
+	OP_SUB<<24 | 1<<20 | 1<<16 | 2<<12, // SUB R1, R1, R2
+	OP_JNZ<<24 | 0x000000,              // JNZ 0
+}
+
+// TestSnapshot tests that a VM restored from an encoded snapshot continues
+// exactly as the original.
+func TestSnapshot(t *testing.T) {
+	vm := NewVM()
+	vm.LoadProgram(snapshotProgram)
+	vm.R[1].SetInt64(100)
+	vm.R[2].SetInt64(1)
+	vm.A[3] = 0x1234
+	vm.F[4].SetPrec(500).SetMode(big.ToZero).Quo(big.NewFloat(1), big.NewFloat(3))
+	vm.F[5].Neg(vm.F[5]) // -0
+	vm.F[6].SetInf(true)
+	vm.FPCR = FPControl(300, big.ToZero) | 1<<IX
+	vm.Costs, vm.Gas = UniformCosts(1), 1000
+	for i := 0; i < 150; i++ {
+		if err := vm.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := vm.Snapshot().WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// The code page and the stack page hold all of the 64 MB of memory.
+	if buf.Len() > 3*PageSize {
+		t.Errorf("Snapshot failed: expected a compact encoding, got %d bytes", buf.Len())
+	}
+	s, err := ReadSnapshot(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encode(t, s), buf.Bytes()) {
+		t.Error("Snapshot failed: decoded snapshot differs from the original")
+	}
+
+	restored := NewVMWithMemory(FlatMemory(make([]byte, DefaultMemorySize)))
+	restored.Memory.WriteBytes(0x100000, []byte{1, 2, 3}) // cleared by Restore
+	if err := restored.Restore(s); err != nil {
+		t.Fatal(err)
+	}
+	restored.Costs = vm.Costs
+	if f := restored.F[4]; f.Prec() != 500 || f.Mode() != big.ToZero || f.Cmp(vm.F[4]) != 0 {
+		t.Errorf("Restore failed: expected F4 = %v with precision 500, got %v with precision %d", vm.F[4], f, f.Prec())
+	}
+	if restored.FPCR != vm.FPCR {
+		t.Errorf("Restore failed: expected FPCR 0x%08X, got 0x%08X", vm.FPCR, restored.FPCR)
+	}
+	if !restored.F[5].Signbit() || !restored.F[6].IsInf() {
+		t.Errorf("Restore failed: expected F5 = -0 and F6 = +Inf, got %v and %v", restored.F[5], restored.F[6])
+	}
+
+	for _, m := range []*VM{vm, restored} {
+		for m.PC < uint32(len(snapshotProgram)) {
+			if err := m.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if !bytes.Equal(encode(t, restored.Snapshot()), encode(t, vm.Snapshot())) {
+		t.Error("Restore failed: the restored VM did not finish in the same state")
+	}
+	if vm.R[0].Int64() != 5050 {
+		t.Errorf("Step failed: expected R0 = 5050, got %v", vm.R[0])
+	}
+
+	if err := NewVMWithMemory(NewSparseMemory(PageSize)).Restore(s); err == nil {
+		t.Error("Restore failed: expected an error for a smaller memory")
+	}
+}
+
+// encode returns the encoding of s.
+func encode(t *testing.T, s *Snapshot) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := s.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestSnapshotErrors tests that malformed snapshots are rejected.
+func TestSnapshotErrors(t *testing.T) {
+	vm := NewVM()
+	vm.LoadProgram(snapshotProgram)
+	data := encode(t, vm.Snapshot())
+
+	for n := 0; n < len(data); n++ {
+		if _, err := ReadSnapshot(bytes.NewReader(data[:n])); err != ErrSnapshot {
+			t.Errorf("truncated to %d bytes: expected ErrSnapshot, got %v", n, err)
+		}
+	}
+	bad := bytes.Clone(data)
+	bad[0] = 'X'
+	if _, err := ReadSnapshot(bytes.NewReader(bad)); err != ErrSnapshot {
+		t.Errorf("bad magic: expected ErrSnapshot, got %v", err)
+	}
+	bad = bytes.Clone(data)
+	bad[5] = snapshotVersion + 1
+	if _, err := ReadSnapshot(bytes.NewReader(bad)); err == nil || err == ErrSnapshot {
+		t.Errorf("future version: expected version error, got %v", err)
+	}
+
+	s := vm.Snapshot()
+	s.FPCR = FPControl(FloatPrec, 7)
+	if _, err := ReadSnapshot(bytes.NewReader(encode(t, s))); err != ErrSnapshot {
+		t.Errorf("bad FPCR: expected ErrSnapshot, got %v", err)
+	}
+	if err := vm.Restore(s); err == nil {
+		t.Error("Restore failed: expected an error for a bad FPCR")
+	}
+
+	// F0 follows the magic, the version and R0-R7, and starts with its
+	// precision.
+	s = vm.Snapshot()
+	s.F[0].SetPrec(MaxFloatPrec).SetInt64(3)
+	bad = encode(t, s)
+	binary.BigEndian.PutUint32(bad[4+2+8*32:], 1<<18)
+	if _, err := ReadSnapshot(bytes.NewReader(bad)); err != ErrSnapshot {
+		t.Errorf("F0 precision 2^18: expected ErrSnapshot, got %v", err)
+	}
+
+	// Restore and WriteTo reject registers a VM cannot hold.
+	for name, edit := range map[string]func(s *Snapshot){
+		"F0 precision 2^18": func(s *Snapshot) { s.F[0].SetPrec(1 << 18) },
+		"nil F1":            func(s *Snapshot) { s.F[1] = nil },
+		"nil R2":            func(s *Snapshot) { s.R[2] = nil },
+		"negative R3":       func(s *Snapshot) { s.R[3].SetInt64(-1) },
+		"R4 of 257 bits":    func(s *Snapshot) { s.R[4].Lsh(big.NewInt(1), 256) },
+	} {
+		s := vm.Snapshot()
+		edit(s)
+		if err := vm.Restore(s); err == nil {
+			t.Errorf("Restore with %s: expected an error", name)
+		}
+		if _, err := s.WriteTo(io.Discard); err == nil {
+			t.Errorf("WriteTo with %s: expected an error", name)
+		}
+	}
+}
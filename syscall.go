@@ -0,0 +1,162 @@
+package tmach
+
+import (
+	"io"
+	"time"
+)
+
+// Syscall is a host function called by the SYSCALL instruction.
+//
+// Arguments are passed in R0-R7 and A0-A7 and results returned in R0, so a
+// host function reads and writes the registers of vm directly. If it returns
+// a *Fault, the SYSCALL instruction faults with that kind. ErrWait, or an
+// error wrapping it, leaves the call to the host to complete, and any other
+// error is returned from Step with PC left at the SYSCALL. A host function
+// whose work grows with its arguments charges for it with UseGas.
+//
+// RunContext cannot interrupt a host function, so one that blocks, as the
+// default SYS_READ does until Stdin has data, holds up the machine until it
+// returns.
+type Syscall func(vm *VM) error
+
+// System call numbers of the default set registered by NewVM.
+const (
+	SYS_EXIT  = 0 // Halt with exit code R0
+	SYS_WRITE = 1 // Write R1 bytes at [A0] to file R0; R0 = bytes written
+	SYS_READ  = 2 // Read up to R1 bytes from file R0 to [A0], blocking; R0 = bytes read
+	SYS_TIME  = 3 // R0 = Unix time in nanoseconds
+)
+
+// RegisterSyscall registers fn as system call n, replacing any previous
+// function. A nil fn removes the system call.
+func (vm *VM) RegisterSyscall(n uint16, fn Syscall) {
+	if fn == nil {
+		delete(vm.syscalls, n)
+		return
+	}
+	if vm.syscalls == nil {
+		vm.syscalls = make(map[uint16]Syscall)
+	}
+	vm.syscalls[n] = fn
+}
+
+// Syscall calls system call n. It returns a FaultSyscall fault if no
+// function is registered for n.
+func (vm *VM) Syscall(n uint16) error {
+	fn := vm.syscalls[n]
+	if fn == nil {
+		return &Fault{Kind: FaultSyscall}
+	}
+	return fn(vm)
+}
+
+// registerDefaultSyscalls registers the default system calls.
+func (vm *VM) registerDefaultSyscalls() {
+	vm.RegisterSyscall(SYS_EXIT, sysExit)
+	vm.RegisterSyscall(SYS_WRITE, sysWrite)
+	vm.RegisterSyscall(SYS_READ, sysRead)
+	vm.RegisterSyscall(SYS_TIME, sysTime)
+}
+
+// setResult stores the signed result of a system call in R0.
+func (vm *VM) setResult(v int64) {
+	wrap(vm.R[0].SetInt64(v))
+}
+
+// buffer returns the memory range [A0, A0+R1) of a read or write system
+// call, or a FaultMemory fault if it does not lie inside memory. The range
+// is charged IOWordCost gas per 32-byte word, and a FaultOutOfGas fault is
+// returned if Gas does not cover it.
+func (vm *VM) buffer() (addr, n uint32, err error) {
+	addr = vm.A[0]
+	size := vm.Unsigned(1)
+	if !size.IsUint64() || uint64(addr)+size.Uint64() > uint64(vm.Memory.Size()) {
+		return 0, 0, &Fault{Kind: FaultMemory, Addr: addr}
+	}
+	n = uint32(size.Uint64())
+	if err := vm.UseGas((uint64(n) + 31) / 32 * IOWordCost); err != nil {
+		return 0, 0, err
+	}
+	return addr, n, nil
+}
+
+// fd returns the file descriptor in R0, or -1 if it is out of range.
+func (vm *VM) fd() int64 {
+	fd := vm.Signed(0)
+	if !fd.IsInt64() {
+		return -1
+	}
+	return fd.Int64()
+}
+
+// writer returns the stream of file descriptor R0: Stdout for 1 and Stderr
+// for 2.
+func (vm *VM) writer() io.Writer {
+	switch vm.fd() {
+	case 1:
+		return vm.Stdout
+	case 2:
+		return vm.Stderr
+	}
+	return nil
+}
+
+// reader returns the stream of file descriptor R0: Stdin for 0.
+func (vm *VM) reader() io.Reader {
+	if vm.fd() == 0 {
+		return vm.Stdin
+	}
+	return nil
+}
+
+func sysExit(vm *VM) error {
+	vm.Halt(int(vm.Signed(0).Int64()))
+	return nil
+}
+
+func sysWrite(vm *VM) error {
+	addr, n, err := vm.buffer()
+	if err != nil {
+		return err
+	}
+	w := vm.writer()
+	if w == nil {
+		vm.setResult(-1)
+		return nil
+	}
+	buf := make([]byte, n)
+	vm.Memory.ReadBytes(addr, buf)
+	written, err := w.Write(buf)
+	if err != nil && written == 0 {
+		vm.setResult(-1)
+		return nil
+	}
+	vm.setResult(int64(written))
+	return nil
+}
+
+func sysRead(vm *VM) error {
+	addr, n, err := vm.buffer()
+	if err != nil {
+		return err
+	}
+	r := vm.reader()
+	if r == nil {
+		vm.setResult(-1)
+		return nil
+	}
+	buf := make([]byte, n)
+	read, err := r.Read(buf)
+	if err != nil && err != io.EOF && read == 0 {
+		vm.setResult(-1)
+		return nil
+	}
+	vm.Memory.WriteBytes(addr, buf[:read])
+	vm.setResult(int64(read))
+	return nil
+}
+
+func sysTime(vm *VM) error {
+	vm.setResult(time.Now().UnixNano())
+	return nil
+}
@@ -0,0 +1,136 @@
+package tmach
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSyscall tests the default system calls.
+func TestSyscall(t *testing.T) {
+	vm := NewVM()
+	var stdout bytes.Buffer
+	vm.Stdin = strings.NewReader("tmach")
+	vm.Stdout = &stdout
+	vm.Stderr = nil
+	vm.Memory.WriteBytes(0x1000, []byte("hello\n"))
+	vm.LoadProgram([]uint32{
+		OP_LDI<<24 | 0<<20 | 1,      // LDI R0, 1
+		OP_LDA<<24 | 0<<20 | 0x1000, // LDI A0, 0x1000
+		OP_LDI<<24 | 1<<20 | 6,      // LDI R1, 6
+		OP_SYSCALL<<24 | SYS_WRITE,  // SYSCALL 1
+		OP_MOV<<24 | 7<<20 | 0<<16,  // MOV R7, R0
+		OP_LDI<<24 | 0<<20 | 0,      // LDI R0, 0
+		OP_LDA<<24 | 0<<20 | 0x2000, // LDI A0, 0x2000
+		OP_LDI<<24 | 1<<20 | 16,     // LDI R1, 16
+		OP_SYSCALL<<24 | SYS_READ,   // SYSCALL 2
+		OP_MOV<<24 | 6<<20 | 0<<16,  // MOV R6, R0
+		OP_LDI<<24 | 0<<20 | 2,      // LDI R0, 2
+		OP_SYSCALL<<24 | SYS_WRITE,  // SYSCALL 1
+		OP_MOV<<24 | 5<<20 | 0<<16,  // MOV R5, R0
+		OP_SYSCALL<<24 | SYS_TIME,   // SYSCALL 3
+		OP_MOV<<24 | 4<<20 | 0<<16,  // MOV R4, R0
+		OP_LDI<<24 | 0<<20 | 3,      // LDI R0, 3
+		OP_SYSCALL<<24 | SYS_EXIT,   // SYSCALL 0
+	})
+
+	start := time.Now().UnixNano()
+	if err := vm.Run(); err != nil || vm.State != Halted || vm.ExitCode != 3 {
+		t.Fatalf("SYS_EXIT failed: expected to halt with exit code 3, got %v, %v, %d", err, vm.State, vm.ExitCode)
+	}
+	if stdout.String() != "hello\n" || vm.R[7].Int64() != 6 {
+		t.Errorf("SYS_WRITE failed: expected 6 bytes %q, got %d bytes %q", "hello\n", vm.R[7], stdout.String())
+	}
+	buf := make([]byte, 5)
+	vm.Memory.ReadBytes(0x2000, buf)
+	if string(buf) != "tmach" || vm.R[6].Int64() != 5 {
+		t.Errorf("SYS_READ failed: expected 5 bytes %q, got %v bytes %q", "tmach", vm.R[6], buf)
+	}
+	if vm.Signed(5).Int64() != -1 {
+		t.Errorf("SYS_WRITE failed: expected -1 for a closed stream, got %v", vm.Signed(5))
+	}
+	if now := vm.R[4].Int64(); now < start || now > time.Now().UnixNano() {
+		t.Errorf("SYS_TIME failed: %d is not between %d and now", now, start)
+	}
+}
+
+// TestRegisterSyscall tests host functions and unregistered system calls.
+func TestRegisterSyscall(t *testing.T) {
+	vm := NewVM()
+	vm.RegisterSyscall(0x1234, func(vm *VM) error {
+		vm.R[0].Add(vm.R[1], vm.R[2])
+		return nil
+	})
+	vm.R[1].SetInt64(40)
+	vm.R[2].SetInt64(2)
+	if err := vm.Execute(OP_SYSCALL<<24 | 0x1234); err != nil || vm.R[0].Int64() != 42 {
+		t.Errorf("SYSCALL failed: expected R0 = 42, got %v, %v", vm.R[0], err)
+	}
+
+	var f *Fault
+	if err := vm.Execute(OP_SYSCALL<<24 | 0x4321); !errors.As(err, &f) || f.Kind != FaultSyscall {
+		t.Errorf("SYSCALL failed: expected unknown system call fault, got %v", err)
+	}
+	vm.RegisterSyscall(SYS_TIME, nil)
+	if err := vm.Execute(OP_SYSCALL<<24 | SYS_TIME); !errors.As(err, &f) || f.Kind != FaultSyscall {
+		t.Errorf("SYSCALL failed: expected unregistered SYS_TIME to fault, got %v", err)
+	}
+
+	// A buffer outside memory is a memory fault.
+	vm.R[0].SetInt64(1)
+	vm.A[0] = vm.Memory.Size() - 4
+	vm.R[1].SetInt64(8)
+	if err := vm.Execute(OP_SYSCALL<<24 | SYS_WRITE); !errors.As(err, &f) || f.Kind != FaultMemory {
+		t.Errorf("SYS_WRITE failed: expected memory fault, got %v", err)
+	}
+}
+
+// TestSyscallGas tests that reads and writes are charged per word of their
+// buffer when gas is metered.
+func TestSyscallGas(t *testing.T) {
+	vm := NewVM()
+	var stdout bytes.Buffer
+	vm.Stdout = &stdout
+	vm.LoadProgram([]uint32{
+		OP_SYSCALL<<24 | SYS_WRITE, // SYSCALL 1
+		OP_SYSCALL<<24 | SYS_WRITE, // SYSCALL 1
+	})
+	vm.Costs = &DefaultCosts
+	vm.Gas = 2*DefaultCosts[OP_SYSCALL] + 3*IOWordCost
+	vm.R[0].SetInt64(1)
+	vm.A[0] = 0x1000
+	vm.R[1].SetInt64(33) // Two words
+	if err := vm.Step(); err != nil || stdout.Len() != 33 {
+		t.Fatalf("SYS_WRITE failed: expected 33 bytes written, got %d, %v", stdout.Len(), err)
+	}
+	if want := DefaultCosts[OP_SYSCALL] + IOWordCost; vm.Gas != want {
+		t.Errorf("SYS_WRITE failed: expected %d gas left, got %d", want, vm.Gas)
+	}
+
+	// The whole of memory costs far more than is left.
+	vm.A[0] = 0
+	vm.R[1].SetUint64(uint64(vm.Memory.Size()))
+	var f *Fault
+	if err := vm.Step(); !errors.As(err, &f) || f.Kind != FaultOutOfGas || vm.PC != 1 {
+		t.Errorf("SYS_WRITE failed: expected out of gas fault at PC 1, got %v at PC %d", err, vm.PC)
+	}
+	if stdout.Len() != 33 || vm.Gas != IOWordCost {
+		t.Errorf("SYS_WRITE failed: expected nothing written and %d gas left, got %d bytes and %d gas",
+			IOWordCost, stdout.Len()-33, vm.Gas)
+	}
+}
+
+// TestSyscallWait tests that a host function may return a wrapped ErrWait.
+func TestSyscallWait(t *testing.T) {
+	vm := NewVM()
+	vm.RegisterSyscall(9, func(vm *VM) error {
+		return fmt.Errorf("request 7: %w", ErrWait)
+	})
+	vm.LoadProgram([]uint32{OP_SYSCALL<<24 | 9}) // SYSCALL 9
+	if err := vm.Step(); !errors.Is(err, ErrWait) || vm.State != Waiting || vm.PC != 1 {
+		t.Errorf("SYSCALL failed: expected to wait at PC 1, got %v in state %v at PC %d", err, vm.State, vm.PC)
+	}
+}
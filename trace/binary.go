@@ -0,0 +1,342 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+
+	"github.com/xtaci/tmach"
+)
+
+// The binary trace starts with the magic "TMTR" and a version byte, followed
+// by a record per executed instruction:
+//
+//	Head   byte     bits 0-6: number of changes; bit 7: PC follows
+//	PC     uvarint  only if bit 7 of Head is set
+//	Word   uint32   the instruction word, big-endian
+//	Change × n      a tag byte followed by its value
+//
+// A record without an explicit PC continues at the PC the previous record
+// ended at, which in a straight run is every record. The change tags are:
+//
+//	0-7    R0-R7  uvarint length n, then n bytes of the big-endian value
+//	8-15   F0-F7  the exact value at its precision, encoded by tmach.AppendFloat
+//	16-23  A0-A7  uvarint
+//	24     SR     byte
+//	25     J      uvarint
+//	26     SP     uvarint
+//	27     error  uvarint FaultKind, 0 for errors other than faults
+//	28     PC     uvarint PC after the instruction, if not PC+1
+//	29     FPCR   uvarint
+const (
+	binaryMagic   = "TMTR"
+	binaryVersion = 1
+)
+
+const (
+	tagR     = 0
+	tagF     = 8
+	tagA     = 16
+	tagSR    = 24
+	tagJ     = 25
+	tagSP    = 26
+	tagError = 27
+	tagPC    = 28
+	tagFPCR  = 29
+)
+
+const headPC = 0x80
+
+// ErrFormat is returned when reading a malformed binary trace.
+var ErrFormat = errors.New("trace: invalid binary trace")
+
+// BinaryWriter writes a compact binary trace that Reader decodes. Only the
+// registers an instruction changed are recorded.
+type BinaryWriter struct {
+	w      *bufio.Writer
+	buf    []byte
+	header bool
+	pc     uint32 // PC the previous record ended at
+	err    error
+}
+
+// NewBinaryWriter returns a BinaryWriter writing to w.
+func NewBinaryWriter(w io.Writer) *BinaryWriter {
+	return &BinaryWriter{w: bufio.NewWriter(w)}
+}
+
+// Before implements tmach.Hook.
+func (t *BinaryWriter) Before(vm *tmach.VM, in tmach.Inst) {}
+
+// After implements tmach.Hook.
+func (t *BinaryWriter) After(vm *tmach.VM, in tmach.Inst, d *tmach.Delta) {
+	if t.err != nil {
+		return
+	}
+	b := t.buf[:0]
+	if !t.header {
+		b = append(b, binaryMagic...)
+		b = append(b, binaryVersion)
+		t.header = true
+	}
+
+	// The head byte is patched once the changes have been counted.
+	head := len(b)
+	b = append(b, 0)
+	n := 0
+	if in.PC != t.pc {
+		b[head] |= headPC
+		b = binary.AppendUvarint(b, uint64(in.PC))
+	}
+	b = binary.BigEndian.AppendUint32(b, in.Word)
+	for _, r := range d.R {
+		v := r.New.Bytes()
+		b = append(b, byte(tagR+r.Reg))
+		b = binary.AppendUvarint(b, uint64(len(v)))
+		b = append(b, v...)
+		n++
+	}
+	for _, f := range d.F {
+		if f.New.Prec() > 8*math.MaxUint16 {
+			t.err = fmt.Errorf("trace: F%d precision %d is too large for a binary trace", f.Reg, f.New.Prec())
+			return
+		}
+		b = append(b, byte(tagF+f.Reg))
+		b = tmach.AppendFloat(b, f.New)
+		n++
+	}
+	for _, a := range d.A {
+		b = append(b, byte(tagA+a.Reg))
+		b = binary.AppendUvarint(b, uint64(a.New))
+		n++
+	}
+	if d.SR != d.OldSR {
+		b = append(b, tagSR, d.SR)
+		n++
+	}
+	if d.FPCR != d.OldFPCR {
+		b = append(b, tagFPCR)
+		b = binary.AppendUvarint(b, uint64(d.FPCR))
+		n++
+	}
+	if d.J != d.OldJ {
+		b = append(b, tagJ)
+		b = binary.AppendUvarint(b, uint64(d.J))
+		n++
+	}
+	if d.SP != d.OldSP {
+		b = append(b, tagSP)
+		b = binary.AppendUvarint(b, uint64(d.SP))
+		n++
+	}
+	if d.Err != nil {
+		var kind tmach.FaultKind
+		var f *tmach.Fault
+		if errors.As(d.Err, &f) {
+			kind = f.Kind
+		}
+		b = append(b, tagError)
+		b = binary.AppendUvarint(b, uint64(kind))
+		n++
+	}
+	if d.PC != in.PC+1 {
+		b = append(b, tagPC)
+		b = binary.AppendUvarint(b, uint64(d.PC))
+		n++
+	}
+	b[head] |= byte(n)
+	t.pc = d.PC
+	t.buf = b
+	_, t.err = t.w.Write(b)
+}
+
+// Flush writes any buffered trace and returns the first write error.
+func (t *BinaryWriter) Flush() error {
+	if t.err != nil {
+		return t.err
+	}
+	return t.w.Flush()
+}
+
+// IntValue is the new value of an integer register.
+type IntValue struct {
+	Reg   int
+	Value *big.Int // Unsigned 256-bit word
+}
+
+// FloatValue is the new value of a floating-point register.
+type FloatValue struct {
+	Reg   int
+	Value *big.Float
+}
+
+// AddrValue is the new value of an address register.
+type AddrValue struct {
+	Reg   int
+	Value uint32
+}
+
+// Record is an instruction read from a binary trace, with the registers it
+// changed. SR, FPCR, J and SP are nil when unchanged.
+type Record struct {
+	PC     uint32
+	Word   uint32
+	NextPC uint32 // PC after the instruction
+	R      []IntValue
+	F      []FloatValue
+	A      []AddrValue
+	SR     *byte
+	FPCR   *uint32
+	J, SP  *uint32
+	Err    bool            // The instruction returned an error
+	Fault  tmach.FaultKind // Kind of the fault, if the error was one
+}
+
+// Reader decodes a binary trace.
+type Reader struct {
+	r      *bufio.Reader
+	header bool
+	pc     uint32
+}
+
+// NewReader returns a Reader decoding the binary trace in r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next record of the trace, or io.EOF at its end.
+func (t *Reader) Next() (*Record, error) {
+	if !t.header {
+		var h [len(binaryMagic) + 1]byte
+		if _, err := io.ReadFull(t.r, h[:]); err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, ErrFormat
+		}
+		if string(h[:len(binaryMagic)]) != binaryMagic {
+			return nil, ErrFormat
+		}
+		if h[len(binaryMagic)] != binaryVersion {
+			return nil, fmt.Errorf("trace: unsupported version %d", h[len(binaryMagic)])
+		}
+		t.header = true
+	}
+
+	head, err := t.r.ReadByte()
+	if err != nil {
+		return nil, err // io.EOF between records ends the trace
+	}
+	rec, err := t.record(head)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrFormat
+		}
+		return nil, err
+	}
+	t.pc = rec.NextPC
+	return rec, nil
+}
+
+func (t *Reader) record(head byte) (*Record, error) {
+	rec := &Record{PC: t.pc}
+	if head&headPC != 0 {
+		pc, err := t.uvarint32()
+		if err != nil {
+			return nil, err
+		}
+		rec.PC = pc
+	}
+	var w [4]byte
+	if _, err := io.ReadFull(t.r, w[:]); err != nil {
+		return nil, err
+	}
+	rec.Word = binary.BigEndian.Uint32(w[:])
+	rec.NextPC = rec.PC + 1
+
+	for i := 0; i < int(head&^headPC); i++ {
+		tag, err := t.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case tag < tagF:
+			n, err := binary.ReadUvarint(t.r)
+			if err != nil {
+				return nil, err
+			}
+			if n > 32 {
+				return nil, ErrFormat
+			}
+			v := make([]byte, n)
+			if _, err := io.ReadFull(t.r, v); err != nil {
+				return nil, err
+			}
+			rec.R = append(rec.R, IntValue{int(tag - tagR), new(big.Int).SetBytes(v)})
+		case tag < tagA:
+			f, err := tmach.ReadFloat(t.r)
+			if err == tmach.ErrFloatEncoding {
+				return nil, ErrFormat
+			}
+			if err != nil {
+				return nil, err
+			}
+			rec.F = append(rec.F, FloatValue{int(tag - tagF), f})
+		case tag < tagSR:
+			v, err := t.uvarint32()
+			if err != nil {
+				return nil, err
+			}
+			rec.A = append(rec.A, AddrValue{int(tag - tagA), v})
+		case tag == tagSR:
+			v, err := t.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			rec.SR = &v
+		case tag == tagJ, tag == tagSP, tag == tagFPCR:
+			v, err := t.uvarint32()
+			if err != nil {
+				return nil, err
+			}
+			switch tag {
+			case tagJ:
+				rec.J = &v
+			case tagSP:
+				rec.SP = &v
+			default:
+				rec.FPCR = &v
+			}
+		case tag == tagError:
+			v, err := binary.ReadUvarint(t.r)
+			if err != nil {
+				return nil, err
+			}
+			rec.Err, rec.Fault = true, tmach.FaultKind(v)
+		case tag == tagPC:
+			v, err := t.uvarint32()
+			if err != nil {
+				return nil, err
+			}
+			rec.NextPC = v
+		default:
+			return nil, ErrFormat
+		}
+	}
+	return rec, nil
+}
+
+func (t *Reader) uvarint32() (uint32, error) {
+	v, err := binary.ReadUvarint(t.r)
+	if err != nil {
+		return 0, err
+	}
+	if v > 0xFFFFFFFF {
+		return 0, ErrFormat
+	}
+	return uint32(v), nil
+}
@@ -0,0 +1,87 @@
+// Package trace records the instructions executed by a tmach virtual
+// machine. Its writers implement tmach.Hook; install one as VM.Hook:
+//
+//	tw := trace.NewTextWriter(os.Stderr)
+//	vm.Hook = tw
+//	err := vm.Run()
+//	tw.Flush()
+//
+// TextWriter emits one human-readable line per instruction. BinaryWriter
+// emits a compact encoding for long runs, which Reader decodes.
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/xtaci/tmach"
+	"github.com/xtaci/tmach/asm"
+)
+
+// TextWriter writes a line per executed instruction: its address, encoding
+// and disassembly, followed by the registers it changed, e.g.
+//
+//	000003 01001000 ADD R0, R0, R1           R0=6 SR=00->10
+type TextWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// NewTextWriter returns a TextWriter writing to w.
+func NewTextWriter(w io.Writer) *TextWriter {
+	return &TextWriter{w: bufio.NewWriter(w)}
+}
+
+// Before implements tmach.Hook.
+func (t *TextWriter) Before(vm *tmach.VM, in tmach.Inst) {}
+
+// After implements tmach.Hook.
+func (t *TextWriter) After(vm *tmach.VM, in tmach.Inst, d *tmach.Delta) {
+	if t.err != nil {
+		return
+	}
+	text, err := asm.Disassemble(in.Word)
+	if err != nil {
+		text = "?"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%06X %08X %-24s", in.PC, in.Word, text)
+	for _, r := range d.R {
+		fmt.Fprintf(&b, " R%d=%v", r.Reg, vm.Signed(r.Reg))
+	}
+	for _, f := range d.F {
+		fmt.Fprintf(&b, " F%d=%s", f.Reg, f.New.Text('g', 20))
+	}
+	for _, a := range d.A {
+		fmt.Fprintf(&b, " A%d=%08X", a.Reg, a.New)
+	}
+	if d.SR != d.OldSR {
+		fmt.Fprintf(&b, " SR=%02X->%02X", d.OldSR, d.SR)
+	}
+	if d.FPCR != d.OldFPCR {
+		fmt.Fprintf(&b, " FPCR=%08X->%08X", d.OldFPCR, d.FPCR)
+	}
+	if d.J != d.OldJ {
+		fmt.Fprintf(&b, " J=%06X", d.J)
+	}
+	if d.SP != d.OldSP {
+		fmt.Fprintf(&b, " SP=%08X", d.SP)
+	}
+	if d.PC != in.PC+1 && d.Err == nil {
+		fmt.Fprintf(&b, " PC=%06X", d.PC)
+	}
+	if d.Err != nil {
+		fmt.Fprintf(&b, " error: %v", d.Err)
+	}
+	_, t.err = t.w.WriteString(strings.TrimRight(b.String(), " ") + "\n")
+}
+
+// Flush writes any buffered trace and returns the first write error.
+func (t *TextWriter) Flush() error {
+	if t.err != nil {
+		return t.err
+	}
+	return t.w.Flush()
+}
@@ -0,0 +1,173 @@
+package trace
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/xtaci/tmach"
+	"github.com/xtaci/tmach/asm"
+)
+
+const testProgram = `
+	LDI R0, 2
+	LDI R1, 1
+loop:
+	SUB R0, R0, R1
+	JNZ loop
+	ITOF F0, R1
+	LDI F1, 3
+	DIV F0, F0, F1
+	PUSH R1
+	LDI A2, 0x10
+	.word 0xFF000000
+`
+
+// run executes testProgram with hook installed until it faults.
+func run(t *testing.T, hook tmach.Hook) {
+	t.Helper()
+	prog, err := asm.Assemble([]byte(testProgram))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := tmach.NewVM()
+	vm.LoadProgram(prog.Code)
+	vm.Hook = hook
+	var f *tmach.Fault
+	if err := vm.Run(); !errors.As(err, &f) || f.Kind != tmach.FaultOpcode {
+		t.Fatalf("Run: expected opcode fault, got %v", err)
+	}
+}
+
+// TestTextWriter tests the text trace of a short program.
+func TestTextWriter(t *testing.T) {
+	var buf bytes.Buffer
+	tw := NewTextWriter(&buf)
+	run(t, tw)
+	if err := tw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `000000 18000002 LDI R0, 2                R0=2
+000001 18100001 LDI R1, 1                R1=1
+000002 02001000 SUB R0, R0, R1           R0=1
+000003 14000002 JNZ 0x000002             J=000004 PC=000002
+000002 02001000 SUB R0, R0, R1           R0=0 SR=00->01
+000003 14000002 JNZ 0x000002
+000004 07810000 ITOF F0, R1              F0=1
+000005 18900003 LDI F1, 3                F1=3
+000006 04889000 DIV F0, F0, F1           F0=0.33333333333333333333 SR=01->00 FPCR=00ED0000->00ED0008
+000007 21010000 PUSH R1                  SP=03FFFFE0
+000008 1E200010 LDI A2, 16               A2=00000010
+000009 FF000000 ?                        error: tmach: unknown opcode (PC 0x9, instruction 0xFF000000)
+`
+	if buf.String() != expected {
+		t.Errorf("TextWriter failed: expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+// TestBinaryRoundTrip tests that Reader decodes what BinaryWriter writes.
+func TestBinaryRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	bw := NewBinaryWriter(&buf)
+	run(t, bw)
+	if err := bw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	var recs []*Record
+	r := NewReader(bytes.NewReader(data))
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+
+	pcs := []uint32{0, 1, 2, 3, 2, 3, 4, 5, 6, 7, 8, 9}
+	if len(recs) != len(pcs) {
+		t.Fatalf("Reader failed: expected %d records, got %d", len(pcs), len(recs))
+	}
+	for i, rec := range recs {
+		if rec.PC != pcs[i] {
+			t.Errorf("record %d: expected PC %d, got %d", i, pcs[i], rec.PC)
+		}
+	}
+	if rec := recs[3]; rec.NextPC != 2 || rec.J == nil || *rec.J != 4 {
+		t.Errorf("JNZ record: expected NextPC 2 and J 4, got %+v", rec)
+	}
+	if rec := recs[4]; len(rec.R) != 1 || rec.R[0].Reg != 0 || rec.R[0].Value.Sign() != 0 || rec.SR == nil || *rec.SR != 1 {
+		t.Errorf("SUB record: expected R0=0 and SR=01, got %+v", rec)
+	}
+	if rec := recs[6]; len(rec.F) != 1 || rec.F[0].Reg != 0 || rec.F[0].Value.Cmp(big.NewFloat(1)) != 0 {
+		t.Errorf("ITOF record: expected F0=1, got %+v", rec)
+	}
+	if rec := recs[8]; rec.FPCR == nil || *rec.FPCR != tmach.DefaultFPCR|1<<tmach.IX {
+		t.Errorf("DIV record: expected FPCR=00ED0008, got %+v", rec)
+	}
+	if rec := recs[9]; rec.SP == nil || *rec.SP != 0x03FFFFE0 {
+		t.Errorf("PUSH record: expected SP=03FFFFE0, got %+v", rec)
+	}
+	if rec := recs[10]; len(rec.A) != 1 || rec.A[0] != (AddrValue{2, 16}) {
+		t.Errorf("LDI record: expected A2=16, got %+v", rec)
+	}
+	if rec := recs[11]; !rec.Err || rec.Fault != tmach.FaultOpcode || rec.NextPC != 9 {
+		t.Errorf("fault record: expected opcode fault, got %+v", rec)
+	}
+
+	// Every truncation of the trace is malformed, except at record boundaries.
+	for n := 1; n < len(data); n++ {
+		r := NewReader(bytes.NewReader(data[:n]))
+		var err error
+		for err == nil {
+			_, err = r.Next()
+		}
+		if err != io.EOF && err != ErrFormat {
+			t.Errorf("truncated to %d bytes: unexpected error %v", n, err)
+		}
+	}
+	if _, err := NewReader(strings.NewReader("TMTX\x01")).Next(); err != ErrFormat {
+		t.Errorf("bad magic: expected ErrFormat, got %v", err)
+	}
+	if _, err := NewReader(strings.NewReader("TMTR\x02")).Next(); err == nil || err == ErrFormat {
+		t.Errorf("future version: expected version error, got %v", err)
+	}
+}
+
+// TestBinaryFloatPrecision tests that a binary trace records F registers
+// exactly at precisions beyond binary256.
+func TestBinaryFloatPrecision(t *testing.T) {
+	var buf bytes.Buffer
+	bw := NewBinaryWriter(&buf)
+	vm := tmach.NewVM()
+	vm.LoadProgram([]uint32{tmach.OP_DIV<<24 | 8<<20 | 9<<16 | 10<<12}) // DIV F0, F1, F2
+	vm.FPCR = tmach.FPControl(1000, big.ToZero)
+	vm.F[1].SetInt64(1)
+	vm.F[2].SetInt64(3)
+	vm.Hook = bw
+	if err := vm.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := NewReader(&buf).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.F) != 1 {
+		t.Fatalf("DIV record: expected one F change, got %+v", rec)
+	}
+	f := rec.F[0].Value
+	if f.Prec() != 1000 || f.Mode() != big.ToZero || f.Cmp(vm.F[0]) != 0 {
+		t.Errorf("DIV record: expected %v at 1000 bits, got %v at %d bits", vm.F[0], f, f.Prec())
+	}
+}
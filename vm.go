@@ -1,10 +1,56 @@
 package tmach
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"math/big"
+	"math/bits"
+	"os"
+	"slices"
 )
 
+// InstructionSize is the size in bytes of an encoded instruction in memory.
+// Instructions are stored as big-endian 32-bit words and the program counter
+// counts instructions, so the instruction at PC lives at byte PC*InstructionSize.
+const InstructionSize = 4
+
+// ErrHalted is returned by Step when the machine has halted, by HALT or
+// the exit system call.
+var ErrHalted = errors.New("tmach: machine halted")
+
+// ErrWait is returned by a system call that leaves its result to the host.
+// Step then puts the machine in the Waiting state with PC past the SYSCALL
+// and returns ErrWait; the host stores the result and resumes the machine.
+var ErrWait = errors.New("tmach: waiting on host")
+
+// State is the run state of a machine.
+type State int
+
+const (
+	Running State = iota // Ready to execute the instruction at PC
+	Halted               // Stopped by HALT or the exit system call; see ExitCode
+	Faulted              // Stopped by a fault or error that was not handled
+	Waiting              // Waiting for the host to complete a system call
+)
+
+func (s State) String() string {
+	switch s {
+	case Running:
+		return "running"
+	case Halted:
+		return "halted"
+	case Faulted:
+		return "faulted"
+	case Waiting:
+		return "waiting"
+	}
+	return fmt.Sprintf("state %d", int(s))
+}
+
 // VM represents the tmach virtual machine.
 type VM struct {
 	// 256-bit general-purpose registers.
@@ -20,30 +66,98 @@ type VM struct {
 	// Bit0: Zero Flag (ZF)
 	// Bit1: Overflow Flag (OF)
 	// Bit2: Divide-by-Zero Flag (DF)
-	// Bits3-5: Comparison Result (CR) (e.g., LT, GT, EQ)
-	// Bits6-7: Reserved
+	// Bits3-5: Comparison Result (CR): LT, GT and EQ, written only by CMP and SCMP
+	// Bit6: Carry Flag (CF)
+	// Bit7: Reserved
 	SR byte
 
+	// 32-bit floating-point control register. Every instruction that writes
+	// an F register rounds its result to the precision and in the rounding
+	// mode selected here; IX, UF and OV stay set until FPCR is written.
+	// Bits0-2: Rounding Mode (RM), numbered as big.RoundingMode
+	// Bit3: Inexact Flag (IX)
+	// Bit4: Underflow Flag (UF)
+	// Bit5: Overflow Flag (OV)
+	// Bits6-15: Reserved
+	// Bits16-31: Precision in bits, at most MaxFloatPrec
+	FPCR uint32
+
 	// Program Counter (PC) holds the current instruction address.
 	PC uint32
 
 	// Jump Return Register (J) holds the return address after a jump.
 	J uint32
 
+	// Stack Pointer (SP) holds the address of the top of the stack.
+	// The stack grows down from StackBase, its initial SP, and may not
+	// grow below StackLimit.
+	SP         uint32
+	StackBase  uint32
+	StackLimit uint32
+
+	// State is the run state of the machine, and ExitCode the status it
+	// halted with.
+	State    State
+	ExitCode int
+
 	// Memory of the virtual machine (e.g., 64 MB).
-	Memory [64 * 1024 * 1024]byte
+	Memory Memory
+
+	// FaultHandler, if set, is given faults raised during Step.
+	FaultHandler FaultHandler
+
+	// Gas is the budget left for Step to spend. If Costs is set, each
+	// instruction is charged Costs[opcode] before it executes, and Step
+	// raises FaultOutOfGas when Gas cannot cover it. A nil Costs disables
+	// metering.
+	Gas   uint64
+	Costs *CostTable
+
+	// Streams of the file descriptors 0, 1 and 2 of the default system
+	// calls. NewVM connects them to the standard streams of the process;
+	// a nil stream makes reads or writes of its descriptor fail.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// syscalls maps system call numbers to host functions.
+	syscalls map[uint16]Syscall
+
+	// Hook, if set, observes every instruction executed by Step.
+	Hook Hook
+	hook *hookState
+
+	// jumped records whether the instruction being executed set PC itself,
+	// by jumping or by skipping literal words, in which case Step must not
+	// advance PC.
+	jumped bool
 }
 
-// NewVM initializes and returns a new virtual machine.
+// DefaultStackSize is the size of the stack region set up by NewVM at the
+// top of memory.
+const DefaultStackSize = 1024 * 1024
+
+// NewVM initializes and returns a new virtual machine with
+// DefaultMemorySize bytes of sparse memory.
 func NewVM() *VM {
-	vm := &VM{}
+	return NewVMWithMemory(NewSparseMemory(DefaultMemorySize))
+}
+
+// NewVMWithMemory initializes and returns a new virtual machine using mem as
+// its memory. The stack is placed at the top of mem, and the default system
+// calls are registered.
+func NewVMWithMemory(mem Memory) *VM {
+	vm := &VM{Memory: mem, FPCR: DefaultFPCR, Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+	vm.StackBase = mem.Size()
+	vm.StackLimit = vm.StackBase - min(vm.StackBase, DefaultStackSize)
+	vm.SP = vm.StackBase
 	for i := range vm.R {
 		vm.R[i] = new(big.Int)
 	}
 	for i := range vm.F {
-		// Set floating-point precision to 256 bits.
-		vm.F[i] = new(big.Float).SetPrec(256)
+		vm.F[i] = vm.float()
 	}
+	vm.registerDefaultSyscalls()
 	return vm
 }
 
@@ -61,55 +175,347 @@ func (vm *VM) GetFlag(flag int) bool {
 	return (vm.SR>>flag)&1 == 1
 }
 
+// ===================================================================
+// Floating-Point Control
+// ===================================================================
+
+// DefaultFPCR is the FPCR set by NewVM: FloatPrec bits, rounded to nearest
+// even, with no flags set.
+const DefaultFPCR = FloatPrec << FPPrec
+
+// MaxFloatPrec is the largest precision FPCR can select. It bounds the work
+// of a floating-point instruction, which DefaultCosts charges for at a fixed
+// rate.
+const MaxFloatPrec = 1024
+
+// fpcrReserved selects the reserved bits of FPCR.
+const fpcrReserved = 1<<FPPrec - 1<<(OV+1)
+
+// FPControl returns the FPCR value selecting a precision of prec bits and
+// the rounding mode mode, with no flags set.
+func FPControl(prec uint, mode big.RoundingMode) uint32 {
+	return uint32(prec)<<FPPrec | uint32(mode)<<RM
+}
+
+// validFPCR reports whether v selects a rounding mode and a precision from 1
+// to MaxFloatPrec bits and leaves the reserved bits clear.
+func validFPCR(v uint32) bool {
+	prec := v >> FPPrec
+	return big.RoundingMode(v>>RM&7) <= big.ToPositiveInf && prec != 0 && prec <= MaxFloatPrec &&
+		v&fpcrReserved == 0
+}
+
+// SetFPCR sets FPCR to v, which also sets or clears its flags. It returns a
+// FaultFloat fault, leaving FPCR unchanged, if v is not a valid FPCR value.
+func (vm *VM) SetFPCR(v uint32) error {
+	if !validFPCR(v) {
+		return &Fault{Kind: FaultFloat}
+	}
+	vm.FPCR = v
+	return nil
+}
+
+// RoundingMode returns the rounding mode selected by FPCR.
+func (vm *VM) RoundingMode() big.RoundingMode {
+	return big.RoundingMode(vm.FPCR >> RM & 7)
+}
+
+// Precision returns the precision in bits selected by FPCR.
+func (vm *VM) Precision() uint {
+	return uint(vm.FPCR >> FPPrec)
+}
+
+// GetFPFlag returns true if the specified flag is set in FPCR.
+func (vm *VM) GetFPFlag(flag int) bool {
+	return (vm.FPCR>>flag)&1 == 1
+}
+
+// raise sets flag in FPCR if value is true. FPCR flags are sticky: only
+// writing FPCR clears them.
+func (vm *VM) raise(flag int, value bool) {
+	if value {
+		vm.FPCR |= 1 << flag
+	}
+}
+
+// float returns a zero Float with the FPCR precision and rounding mode.
+func (vm *VM) float() *big.Float {
+	return newFloat(vm.Precision()).SetMode(vm.RoundingMode())
+}
+
+// roundFloat rounds x to the FPCR precision and rounding mode and stores it
+// in F[fd], which takes that precision. IX is raised if the result is
+// inexact, because of this rounding or of the accuracy x itself reports, and
+// UF or OV if an inexact result is zero or infinite. x must not be an F
+// register.
+func (vm *VM) roundFloat(fd int, x *big.Float) {
+	acc := x.Acc()
+	z := vm.F[fd].SetPrec(vm.Precision()).SetMode(vm.RoundingMode()).Set(x)
+	inexact := acc != big.Exact || z.Acc() != big.Exact
+	vm.raise(IX, inexact)
+	vm.raise(UF, inexact && z.Sign() == 0)
+	vm.raise(OV, inexact && z.IsInf())
+}
+
+// setFloat stores x in F[fd] as roundFloat does and sets ZF if the result
+// is zero. A nil x, the result of an operation that is not a real number,
+// is a FaultFloat fault and leaves F[fd] unchanged.
+func (vm *VM) setFloat(fd int, x *big.Float) error {
+	if x == nil {
+		return &Fault{Kind: FaultFloat}
+	}
+	vm.roundFloat(fd, x)
+	vm.SetFlag(ZF, vm.F[fd].Sign() == 0)
+	return nil
+}
+
 // ===================================================================
 // Memory Access Instructions (Address is read directly from an address register)
 // ===================================================================
 
 // Load loads 32 bytes (256 bits) from memory at the address given by A[ax] into the target register.
 // For integer registers (rd in 0..7), load into R; for floating-point registers (rd in 8..15), load into F.
-func (vm *VM) Load(rd int, ax int) {
+// It returns a FaultMemory fault if the access is out of bounds.
+func (vm *VM) Load(rd int, ax int) error {
 	addr := vm.A[ax]
-	if addr+32 > uint32(len(vm.Memory)) {
-		fmt.Println("Memory read out of bounds")
+	if uint64(addr)+32 > uint64(vm.Memory.Size()) {
+		return &Fault{Kind: FaultMemory, Addr: addr}
+	}
+	data := make([]byte, 32)
+	vm.Memory.ReadBytes(addr, data)
+	return vm.setRegBytes(rd, data)
+}
+
+// Store stores 32 bytes (256 bits) from the source register into memory at the address given by A[ax].
+// For integer registers (rs in 0..7), store from R; for floating-point registers (rs in 8..15), store from F.
+// It returns a FaultMemory fault if the access is out of bounds.
+func (vm *VM) Store(rs int, ax int) error {
+	addr := vm.A[ax]
+	if uint64(addr)+32 > uint64(vm.Memory.Size()) {
+		return &Fault{Kind: FaultMemory, Addr: addr}
+	}
+	vm.Memory.WriteBytes(addr, vm.regBytes(rs))
+	return nil
+}
+
+// regBytes returns the 32-byte memory representation of R[rs] (rs in 0..7) or F[rs-8] (rs in 8..15).
+// Integers are stored as big-endian 256-bit words.
+func (vm *VM) regBytes(rs int) []byte {
+	padded := make([]byte, 32)
+	if rs < 8 {
+		unsigned(vm.R[rs]).FillBytes(padded)
+	} else {
+		// Floating-point values are stored in IEEE 754 binary256 format,
+		// rounded in the FPCR rounding mode.
+		b := encodeFloat256(vm.F[rs-8], vm.RoundingMode())
+		vm.raiseStored(vm.F[rs-8], b[:])
+		copy(padded, b[:])
+	}
+	return padded
+}
+
+// raiseStored raises the FPCR flags for storing x as the binary256 value b:
+// IX if b is not exactly x, OV if x is finite but beyond the largest finite
+// binary256 value once rounded, and UF if an inexact x is below the
+// smallest normal one.
+func (vm *VM) raiseStored(x *big.Float, b []byte) {
+	y, _ := DecodeFloat256(b)
+	if y.Cmp(x) == 0 || x.IsInf() {
 		return
 	}
+	e := exponent(x) // x is finite and non-zero, as it did not encode exactly
+	vm.raise(IX, true)
+	vm.raise(OV, y.IsInf() || e > float256EMax+1)
+	vm.raise(UF, e <= float256EMin)
+}
+
+// setRegBytes sets R[rd] (rd in 0..7) or F[rd-8] (rd in 8..15) from its 32-byte memory representation.
+// It returns a FaultFloat fault, leaving the register unchanged, if a floating-point value is a NaN.
+func (vm *VM) setRegBytes(rd int, data []byte) error {
 	if rd < 8 {
 		// Load 256-bit integer value.
-		vm.R[rd].SetBytes(vm.Memory[addr : addr+32])
-	} else if rd < 16 {
+		vm.R[rd].SetBytes(data)
+	} else {
 		// Load 256-bit floating-point value.
-		bitsVal := new(big.Int).SetBytes(vm.Memory[addr : addr+32])
-		vm.F[rd-8].SetInt(bitsVal)
+		f, err := DecodeFloat256(data)
+		if err != nil {
+			return &Fault{Kind: FaultFloat}
+		}
+		vm.roundFloat(rd-8, f)
+	}
+	return nil
+}
+
+// ===================================================================
+// Data Movement Instructions
+// ===================================================================
+
+// LoadImm loads the unsigned immediate imm into R[rd] (rd in 0..7) or F[rd-8] (rd in 8..15).
+func (vm *VM) LoadImm(rd int, imm uint32) {
+	if rd < 8 {
+		vm.R[rd].SetUint64(uint64(imm))
 	} else {
-		// If needed, address registers could be loaded here.
-		// For now, we assume only R and F are used.
+		vm.roundFloat(rd-8, new(big.Float).SetUint64(uint64(imm)))
 	}
 }
 
-// Store stores 32 bytes (256 bits) from the source register into memory at the address given by A[ax].
-// For integer registers (rs in 0..7), store from R; for floating-point registers (rs in 8..15), store from F.
-func (vm *VM) Store(rs int, ax int) {
-	addr := vm.A[ax]
-	if addr+32 > uint32(len(vm.Memory)) {
-		fmt.Println("Memory write out of bounds")
-		return
+// LoadWide loads the n literal words following the instruction at PC into R[rd],
+// most significant word first, so that up to eight words build a full 256-bit constant.
+// It returns a FaultMemory fault if the literal runs past the end of memory.
+func (vm *VM) LoadWide(rd int, n int) error {
+	res := new(big.Int)
+	for i := 1; i <= n; i++ {
+		word, ok := vm.fetch(vm.PC + uint32(i))
+		if !ok {
+			return &Fault{Kind: FaultMemory, Addr: (vm.PC + uint32(i)) * InstructionSize}
+		}
+		res.Lsh(res, 32)
+		res.Or(res, new(big.Int).SetUint64(uint64(word)))
 	}
-	if rs < 8 {
-		data := vm.R[rs].Bytes()
-		// Pad to 32 bytes if necessary.
-		padded := make([]byte, 32)
-		copy(padded[32-len(data):], data)
-		copy(vm.Memory[addr:addr+32], padded)
-	} else if rs < 16 {
-		bitsVal := new(big.Int)
-		vm.F[rs-8].Int(bitsVal)
-		data := bitsVal.Bytes()
-		padded := make([]byte, 32)
-		copy(padded[32-len(data):], data)
-		copy(vm.Memory[addr:addr+32], padded)
-	} else {
-		// Not used in this design.
+	vm.R[rd].Set(res)
+	return nil
+}
+
+// Move copies R[rs] to R[rd] for integer registers (0..7), or F[rs-8] to F[rd-8]
+// for floating-point registers (8..15).
+// Between the files it moves the bits unchanged: R[rd] receives the binary256
+// encoding of F[rs-8], as STORE would write it, and F[rd-8] is decoded from R[rs]
+// as LOAD would read it, returning a FaultFloat fault if R[rs] encodes a NaN.
+func (vm *VM) Move(rd, rs int) error {
+	switch {
+	case rd < 8 && rs < 8:
+		vm.R[rd].Set(vm.R[rs])
+	case rd >= 8 && rs >= 8:
+		vm.roundFloat(rd-8, new(big.Float).Set(vm.F[rs-8]))
+	default:
+		return vm.setRegBytes(rd, vm.regBytes(rs))
 	}
+	return nil
+}
+
+// MoveToAddr copies the low 32 bits of R[rs] to A[ad].
+func (vm *VM) MoveToAddr(ad, rs int) {
+	vm.A[ad] = low32(vm.R[rs])
+}
+
+// MoveFromAddr copies A[as] to R[rd].
+func (vm *VM) MoveFromAddr(rd, as int) {
+	vm.R[rd].SetUint64(uint64(vm.A[as]))
+}
+
+// MoveAddr copies A[as] to A[ad].
+func (vm *VM) MoveAddr(ad, as int) {
+	vm.A[ad] = vm.A[as]
+}
+
+// LoadAddr loads the immediate address imm into A[ad].
+func (vm *VM) LoadAddr(ad int, imm uint32) {
+	vm.A[ad] = imm
+}
+
+// AddAddr sets A[ad] to A[as] plus the signed offset off, wrapping around at 32 bits.
+func (vm *VM) AddAddr(ad, as int, off int32) {
+	vm.A[ad] = vm.A[as] + uint32(off)
+}
+
+// AddAddrReg sets A[ad] to A[as] plus the low 32 bits of R[rt], wrapping around at 32 bits.
+func (vm *VM) AddAddrReg(ad, as, rt int) {
+	vm.A[ad] = vm.A[as] + low32(vm.R[rt])
+}
+
+// ===================================================================
+// Stack Instructions (the stack grows down from StackBase towards StackLimit)
+// ===================================================================
+
+// push decrements SP by len(data) and writes data at the new SP.
+// It returns a FaultStackOverflow fault if the stack would grow below StackLimit.
+func (vm *VM) push(data []byte) error {
+	n := uint32(len(data))
+	if vm.SP < n || vm.SP-n < vm.StackLimit {
+		return &Fault{Kind: FaultStackOverflow, Addr: vm.SP}
+	}
+	if vm.SP > vm.Memory.Size() {
+		return &Fault{Kind: FaultMemory, Addr: vm.SP - n}
+	}
+	vm.SP -= n
+	vm.Memory.WriteBytes(vm.SP, data)
+	return nil
+}
+
+// pop reads n bytes at SP and increments SP past them.
+// It returns a FaultStackUnderflow fault if the stack holds fewer than n bytes.
+func (vm *VM) pop(n uint32) ([]byte, error) {
+	if uint64(vm.SP)+uint64(n) > uint64(vm.StackBase) {
+		return nil, &Fault{Kind: FaultStackUnderflow, Addr: vm.SP}
+	}
+	if uint64(vm.SP)+uint64(n) > uint64(vm.Memory.Size()) {
+		return nil, &Fault{Kind: FaultMemory, Addr: vm.SP}
+	}
+	data := make([]byte, n)
+	vm.Memory.ReadBytes(vm.SP, data)
+	vm.SP += n
+	return data, nil
+}
+
+// Push pushes the 256-bit value of R[rs] (rs in 0..7) or F[rs-8] (rs in 8..15) onto the stack.
+func (vm *VM) Push(rs int) error {
+	fpcr := vm.FPCR
+	if err := vm.push(vm.regBytes(rs)); err != nil {
+		vm.FPCR = fpcr
+		return err
+	}
+	return nil
+}
+
+// Pop pops a 256-bit value from the stack into R[rd] (rd in 0..7) or F[rd-8] (rd in 8..15).
+func (vm *VM) Pop(rd int) error {
+	sp := vm.SP
+	data, err := vm.pop(32)
+	if err != nil {
+		return err
+	}
+	if err := vm.setRegBytes(rd, data); err != nil {
+		vm.SP = sp
+		return err
+	}
+	return nil
+}
+
+// PushAddr pushes the 32-bit value of A[as] onto the stack.
+func (vm *VM) PushAddr(as int) error {
+	return vm.push(binary.BigEndian.AppendUint32(nil, vm.A[as]))
+}
+
+// PopAddr pops a 32-bit value from the stack into A[ad].
+func (vm *VM) PopAddr(ad int) error {
+	data, err := vm.pop(4)
+	if err != nil {
+		return err
+	}
+	vm.A[ad] = binary.BigEndian.Uint32(data)
+	return nil
+}
+
+// Call pushes the return address (the next instruction) onto the stack and jumps to addr.
+// Unlike Jump, it leaves J unchanged, so calls nest.
+func (vm *VM) Call(addr uint32) error {
+	if err := vm.push(binary.BigEndian.AppendUint32(nil, vm.PC+1)); err != nil {
+		return err
+	}
+	vm.PC = addr
+	vm.jumped = true
+	return nil
+}
+
+// Ret pops a return address pushed by Call and jumps to it.
+func (vm *VM) Ret() error {
+	data, err := vm.pop(4)
+	if err != nil {
+		return err
+	}
+	vm.PC = binary.BigEndian.Uint32(data)
+	vm.jumped = true
+	return nil
 }
 
 // ===================================================================
@@ -117,55 +523,59 @@ func (vm *VM) Store(rs int, ax int) {
 // ===================================================================
 
 // Add performs 256-bit addition. It uses integer registers (R) if rd < 8; otherwise, it uses floating-point registers.
+// Integer results wrap around modulo 2^256: CF is set on unsigned carry and OF on signed overflow.
 func (vm *VM) Add(rd, rs, rt int) {
 	if rd < 8 {
-		res := new(big.Int).Add(vm.R[rs], vm.R[rt])
-		vm.R[rd].Set(res)
-		vm.SetFlag(0, res.Sign() == 0)    // Zero Flag
-		vm.SetFlag(1, res.BitLen() > 256) // Overflow Flag
+		a, b := vm.R[rs], vm.R[rt]
+		sum := new(big.Int).Add(unsigned(a), unsigned(b))
+		vm.SetFlag(CF, sum.Cmp(mod256) >= 0)
+		vm.SetFlag(OF, !fitsSigned(new(big.Int).Add(signed(a), signed(b))))
+		vm.R[rd].Set(wrap(sum))
+		vm.SetFlag(ZF, vm.R[rd].Sign() == 0)
 	} else {
-		res := new(big.Float).Add(vm.F[rs-8], vm.F[rt-8])
-		vm.F[rd-8].Set(res)
-		vm.SetFlag(0, res.Sign() == 0) // Zero Flag
+		vm.setFloat(rd-8, vm.float().Add(vm.F[rs-8], vm.F[rt-8]))
 	}
 }
 
 // Sub performs subtraction.
+// Integer results wrap around modulo 2^256: CF is set on unsigned borrow and OF on signed overflow.
 func (vm *VM) Sub(rd, rs, rt int) {
 	if rd < 8 {
-		res := new(big.Int).Sub(vm.R[rs], vm.R[rt])
-		vm.R[rd].Set(res)
-		vm.SetFlag(0, res.Sign() == 0)
-		vm.SetFlag(1, res.BitLen() > 256)
+		a, b := vm.R[rs], vm.R[rt]
+		vm.SetFlag(CF, unsigned(a).Cmp(unsigned(b)) < 0)
+		vm.SetFlag(OF, !fitsSigned(new(big.Int).Sub(signed(a), signed(b))))
+		vm.R[rd].Set(wrap(new(big.Int).Sub(a, b)))
+		vm.SetFlag(ZF, vm.R[rd].Sign() == 0)
 	} else {
-		res := new(big.Float).Sub(vm.F[rs-8], vm.F[rt-8])
-		vm.F[rd-8].Set(res)
-		vm.SetFlag(0, res.Sign() == 0)
+		vm.setFloat(rd-8, vm.float().Sub(vm.F[rs-8], vm.F[rt-8]))
 	}
 }
 
 // Mul performs multiplication.
+// Integer results keep the low 256 bits of the product: CF is set if the unsigned product
+// does not fit in 256 bits and OF if the signed product does not.
 func (vm *VM) Mul(rd, rs, rt int) {
 	if rd < 8 {
-		res := new(big.Int).Mul(vm.R[rs], vm.R[rt])
-		vm.R[rd].Set(res)
-		vm.SetFlag(0, res.Sign() == 0)
-		vm.SetFlag(1, res.BitLen() > 256)
+		a, b := vm.R[rs], vm.R[rt]
+		prod := new(big.Int).Mul(unsigned(a), unsigned(b))
+		vm.SetFlag(CF, prod.Cmp(mod256) >= 0)
+		vm.SetFlag(OF, !fitsSigned(new(big.Int).Mul(signed(a), signed(b))))
+		vm.R[rd].Set(wrap(prod))
+		vm.SetFlag(ZF, vm.R[rd].Sign() == 0)
 	} else {
-		res := new(big.Float).Mul(vm.F[rs-8], vm.F[rt-8])
-		vm.F[rd-8].Set(res)
-		vm.SetFlag(0, res.Sign() == 0)
+		vm.setFloat(rd-8, vm.float().Mul(vm.F[rs-8], vm.F[rt-8]))
 	}
 }
 
 // Div performs division. It checks for division by zero.
+// Integer division is unsigned; see SDiv for signed division.
 func (vm *VM) Div(rd, rs, rt int) {
 	if rd < 8 {
-		if vm.R[rt].Sign() == 0 {
+		if unsigned(vm.R[rt]).Sign() == 0 {
 			vm.SetFlag(2, true) // Divide-by-Zero Flag
 			return
 		}
-		res := new(big.Int).Div(vm.R[rs], vm.R[rt])
+		res := new(big.Int).Quo(unsigned(vm.R[rs]), unsigned(vm.R[rt]))
 		vm.R[rd].Set(res)
 		vm.SetFlag(0, res.Sign() == 0)
 	} else {
@@ -174,31 +584,128 @@ func (vm *VM) Div(rd, rs, rt int) {
 			vm.SetFlag(2, true)
 			return
 		}
-		res := new(big.Float).Quo(vm.F[rs-8], vm.F[rt-8])
-		vm.F[rd-8].Set(res)
-		vm.SetFlag(0, res.Sign() == 0)
+		vm.setFloat(rd-8, vm.float().Quo(vm.F[rs-8], vm.F[rt-8]))
 	}
 }
 
-// Mod performs 256-bit modulo operation. It uses integer registers (R).
-func (vm *VM) Mod(rd, rs, rt int) {
-	if rd < 0 || rd > 7 || rs < 0 || rs > 7 || rt < 0 || rt > 7 {
-		fmt.Println("Invalid register for MOD operation")
+// SDiv performs signed 256-bit division of R[rs] by R[rt], truncating toward zero.
+// Dividing the most negative value by -1 wraps around and sets OF.
+func (vm *VM) SDiv(rd, rs, rt int) {
+	if unsigned(vm.R[rt]).Sign() == 0 {
+		vm.SetFlag(DF, true)
 		return
 	}
+	res := new(big.Int).Quo(signed(vm.R[rs]), signed(vm.R[rt]))
+	vm.SetFlag(OF, !fitsSigned(res))
+	vm.R[rd].Set(wrap(res))
+	vm.SetFlag(ZF, vm.R[rd].Sign() == 0)
+}
+
+// Mod performs unsigned 256-bit modulo operation. It uses integer registers (R)
+// and returns a FaultRegister fault for any other register.
+func (vm *VM) Mod(rd, rs, rt int) error {
+	if rd < 0 || rd > 7 || rs < 0 || rs > 7 || rt < 0 || rt > 7 {
+		return &Fault{Kind: FaultRegister}
+	}
 
 	// Check for division by zero
-	if vm.R[rt].Sign() == 0 {
+	if unsigned(vm.R[rt]).Sign() == 0 {
 		vm.SetFlag(DF, true) // Divide-by-Zero Flag
-		return
+		return nil
 	}
 
 	// Perform modulo operation
-	res := new(big.Int).Mod(vm.R[rs], vm.R[rt])
+	res := new(big.Int).Rem(unsigned(vm.R[rs]), unsigned(vm.R[rt]))
 	vm.R[rd].Set(res)
 
 	// Set Zero Flag (ZF) if the result is zero
 	vm.SetFlag(ZF, res.Sign() == 0)
+	return nil
+}
+
+// SMod computes the signed remainder of R[rs] divided by R[rt], truncating toward zero,
+// so the result has the sign of the dividend.
+func (vm *VM) SMod(rd, rs, rt int) {
+	if unsigned(vm.R[rt]).Sign() == 0 {
+		vm.SetFlag(DF, true)
+		return
+	}
+	res := new(big.Int).Rem(signed(vm.R[rs]), signed(vm.R[rt]))
+	vm.R[rd].Set(wrap(res))
+	vm.SetFlag(ZF, res.Sign() == 0)
+}
+
+// ===================================================================
+// Modular Arithmetic Instructions
+// ===================================================================
+//
+// The modular instructions treat their registers as unsigned and compute
+// the intermediate sum, difference, product or power in full, so the result
+// is exact whatever the operands. A zero modulus sets DF and leaves R[rd]
+// unchanged, as MOD does; ZF is set if the result is zero.
+
+// modulus returns the unsigned value of R[rm], or sets DF and returns nil if
+// it is zero.
+func (vm *VM) modulus(rm int) *big.Int {
+	m := unsigned(vm.R[rm])
+	if m.Sign() == 0 {
+		vm.SetFlag(DF, true)
+		return nil
+	}
+	return m
+}
+
+// setInt stores res, which lies in [0, 2^256), in R[rd] and sets ZF if it is zero.
+func (vm *VM) setInt(rd int, res *big.Int) {
+	vm.R[rd].Set(res)
+	vm.SetFlag(ZF, res.Sign() == 0)
+}
+
+// AddMod stores (R[rs] + R[rt]) mod R[rm] in R[rd].
+func (vm *VM) AddMod(rd, rs, rt, rm int) {
+	if m := vm.modulus(rm); m != nil {
+		res := new(big.Int).Add(unsigned(vm.R[rs]), unsigned(vm.R[rt]))
+		vm.setInt(rd, res.Mod(res, m))
+	}
+}
+
+// SubMod stores (R[rs] - R[rt]) mod R[rm] in R[rd], in [0, R[rm]).
+func (vm *VM) SubMod(rd, rs, rt, rm int) {
+	if m := vm.modulus(rm); m != nil {
+		res := new(big.Int).Sub(unsigned(vm.R[rs]), unsigned(vm.R[rt]))
+		vm.setInt(rd, res.Mod(res, m))
+	}
+}
+
+// MulMod stores (R[rs] × R[rt]) mod R[rm] in R[rd].
+func (vm *VM) MulMod(rd, rs, rt, rm int) {
+	if m := vm.modulus(rm); m != nil {
+		res := new(big.Int).Mul(unsigned(vm.R[rs]), unsigned(vm.R[rt]))
+		vm.setInt(rd, res.Mod(res, m))
+	}
+}
+
+// ExpMod stores R[rs] raised to the power R[rt], mod R[rm], in R[rd].
+func (vm *VM) ExpMod(rd, rs, rt, rm int) {
+	if m := vm.modulus(rm); m != nil {
+		vm.setInt(rd, new(big.Int).Exp(unsigned(vm.R[rs]), unsigned(vm.R[rt]), m))
+	}
+}
+
+// InvMod stores the inverse of R[rs] modulo R[rt] in R[rd]: the x in
+// [0, R[rt]) with R[rs] × x = 1 mod R[rt]. It returns a FaultArithmetic
+// fault, leaving R[rd] unchanged, if R[rs] and R[rt] are not coprime.
+func (vm *VM) InvMod(rd, rs, rt int) error {
+	m := vm.modulus(rt)
+	if m == nil {
+		return nil
+	}
+	res := new(big.Int).ModInverse(unsigned(vm.R[rs]), m)
+	if res == nil {
+		return &Fault{Kind: FaultArithmetic}
+	}
+	vm.setInt(rd, res)
+	return nil
 }
 
 // ===================================================================
@@ -206,37 +713,160 @@ func (vm *VM) Mod(rd, rs, rt int) {
 // ===================================================================
 
 // Compare compares the values in Rs and Rt and sets the status flags accordingly.
-// For integer registers, it updates Zero Flag, Less-Than (bit 3), and Greater-Than (bit 4) flags.
+// For integer registers, it updates Zero Flag, Less-Than (bit 3), and Greater-Than (bit 4) flags,
+// treating the registers as unsigned; see SCompare for signed comparison.
 // For floating-point, similar behavior is applied.
 func (vm *VM) Compare(rs, rt int) {
 	if rs < 8 {
-		cmp := vm.R[rs].Cmp(vm.R[rt])
-		vm.SetFlag(0, cmp == 0) // Zero flag
-		vm.SetFlag(3, cmp < 0)  // LT flag (bit 3)
-		vm.SetFlag(4, cmp > 0)  // GT flag (bit 4)
+		vm.setCompare(unsigned(vm.R[rs]).Cmp(unsigned(vm.R[rt])))
 	} else {
-		cmp := vm.F[rs-8].Cmp(vm.F[rt-8])
-		vm.SetFlag(0, cmp == 0)
-		vm.SetFlag(3, cmp < 0)
-		vm.SetFlag(4, cmp > 0)
+		vm.setCompare(vm.F[rs-8].Cmp(vm.F[rt-8]))
 	}
 }
 
+// SCompare compares the signed values in integer registers R[rs] and R[rt].
+func (vm *VM) SCompare(rs, rt int) {
+	vm.setCompare(signed(vm.R[rs]).Cmp(signed(vm.R[rt])))
+}
+
+// setCompare records the result of a comparison in the comparison result
+// flags. Exactly one of them is set, and the other flags are preserved.
+func (vm *VM) setCompare(cmp int) {
+	vm.SetFlag(LT, cmp < 0)  // LT flag (bit 3)
+	vm.SetFlag(GT, cmp > 0)  // GT flag (bit 4)
+	vm.SetFlag(EQ, cmp == 0) // EQ flag (bit 5)
+}
+
 // ===================================================================
 // Type Conversion Instructions
 // ===================================================================
 
-// ITOF converts an integer in register R[rs] to a floating-point number and stores it in F[fd].
+// ITOF converts the signed integer in register R[rs] to a floating-point number and stores it in F[fd].
 func (vm *VM) ITOF(fd int, rs int) {
-	floatVal := new(big.Float).SetInt(vm.R[rs])
-	vm.F[fd].Set(floatVal)
+	vm.roundFloat(fd, new(big.Float).SetInt(signed(vm.R[rs])))
 }
 
 // FTOI converts a floating-point number in register F[fs] to an integer and stores it in R[rd].
+// The value is rounded to an integer in the FPCR rounding mode, raising IX if it was not one,
+// and stored in two's complement; OF is set if it does not fit in the signed 256-bit range,
+// and an infinity converts to 0.
+// Only the bits of F[fs] that survive the reduction to 256 bits are built, so the cost does
+// not grow with its exponent.
 func (vm *VM) FTOI(rd int, fs int) {
+	x := vm.F[fs]
 	intVal := new(big.Int)
-	vm.F[fs].Int(intVal)
-	vm.R[rd].Set(intVal)
+	switch e, prec := exponent(x), int(x.Prec()); {
+	case x.IsInf():
+		vm.SetFlag(OF, true)
+	case e-prec >= 256:
+		// x is a multiple of 2^256, so its low 256 bits are zero.
+		vm.SetFlag(OF, true)
+	case e > prec:
+		// x is the integer m × 2^(e-prec), with m of at most prec bits.
+		m, _ := new(big.Float).SetMantExp(x, prec-e).Int(nil)
+		intVal.Lsh(m, uint(e-prec))
+		vm.SetFlag(OF, !fitsSigned(intVal))
+	default:
+		intVal = fint(x, vm.RoundingMode())
+		vm.raise(IX, !x.IsInt())
+		vm.SetFlag(OF, !fitsSigned(intVal))
+	}
+	vm.R[rd].Set(wrap(intVal))
+}
+
+// ===================================================================
+// Floating-Point Functions
+// ===================================================================
+
+// fround evaluates f, which computes its result to the working precision
+// it is passed, to the FPCR precision and rounding mode.
+func (vm *VM) fround(f func(w uint) *big.Float) *big.Float {
+	return fround(f, vm.Precision(), vm.RoundingMode())
+}
+
+// Sqrt stores the square root of F[fs] in F[fd]. It returns a FaultFloat
+// fault if F[fs] is negative.
+func (vm *VM) Sqrt(fd, fs int) error {
+	x := vm.F[fs]
+	if x.Sign() < 0 {
+		return vm.setFloat(fd, nil)
+	}
+	return vm.setFloat(fd, vm.fround(func(w uint) *big.Float {
+		return newFloat(w).Sqrt(x)
+	}))
+}
+
+// Abs stores the absolute value of F[fs] in F[fd].
+func (vm *VM) Abs(fd, fs int) {
+	vm.setFloat(fd, new(big.Float).Abs(vm.F[fs]))
+}
+
+// Neg stores the negation of F[fs] in F[fd].
+func (vm *VM) Neg(fd, fs int) {
+	vm.setFloat(fd, new(big.Float).Neg(vm.F[fs]))
+}
+
+// Floor stores the greatest integer not greater than F[fs] in F[fd].
+func (vm *VM) Floor(fd, fs int) {
+	vm.setFloat(fd, froundInt(vm.F[fs], roundFloor))
+}
+
+// Ceil stores the least integer not less than F[fs] in F[fd].
+func (vm *VM) Ceil(fd, fs int) {
+	vm.setFloat(fd, froundInt(vm.F[fs], roundCeil))
+}
+
+// Round stores F[fs] rounded to the nearest integer, with ties away from
+// zero, in F[fd].
+func (vm *VM) Round(fd, fs int) {
+	vm.setFloat(fd, froundInt(vm.F[fs], roundHalfAway))
+}
+
+// Trunc stores the integer part of F[fs] in F[fd].
+func (vm *VM) Trunc(fd, fs int) {
+	vm.setFloat(fd, froundInt(vm.F[fs], roundTrunc))
+}
+
+// Exp stores e raised to F[fs] in F[fd].
+func (vm *VM) Exp(fd, fs int) error {
+	x := vm.F[fs]
+	return vm.setFloat(fd, vm.fround(func(w uint) *big.Float { return fexp(x, w) }))
+}
+
+// Log stores the natural logarithm of F[fs] in F[fd]. The logarithm of zero
+// is -Inf; that of a negative number is a FaultFloat fault.
+func (vm *VM) Log(fd, fs int) error {
+	x := vm.F[fs]
+	return vm.setFloat(fd, vm.fround(func(w uint) *big.Float { return flog(x, w) }))
+}
+
+// Sin stores the sine of F[fs] radians in F[fd]. Infinite arguments, and
+// arguments of magnitude 2^4096 or more, are a FaultFloat fault.
+func (vm *VM) Sin(fd, fs int) error {
+	x := vm.F[fs]
+	return vm.setFloat(fd, vm.fround(func(w uint) *big.Float { return fsincos(x, w, false) }))
+}
+
+// Cos stores the cosine of F[fs] radians in F[fd], with the same faults as
+// Sin.
+func (vm *VM) Cos(fd, fs int) error {
+	x := vm.F[fs]
+	return vm.setFloat(fd, vm.fround(func(w uint) *big.Float { return fsincos(x, w, true) }))
+}
+
+// Atan stores the arctangent of F[fs] in F[fd], in radians between -π/2
+// and π/2.
+func (vm *VM) Atan(fd, fs int) error {
+	x := vm.F[fs]
+	return vm.setFloat(fd, vm.fround(func(w uint) *big.Float { return fatan(x, w) }))
+}
+
+// Pow stores F[fs] raised to the power F[ft] in F[fd]. Special cases follow
+// math.Pow; a negative base with a non-integral exponent is a FaultFloat
+// fault.
+func (vm *VM) Pow(fd, fs, ft int) error {
+	x, y := vm.F[fs], vm.F[ft]
+	return vm.setFloat(fd, vm.fround(func(w uint) *big.Float { return fpow(x, y, w) }))
 }
 
 // ===================================================================
@@ -245,32 +875,29 @@ func (vm *VM) FTOI(rd int, fs int) {
 
 // And performs a bitwise AND on R[rs] and R[rt] and stores the result in R[rd].
 func (vm *VM) And(rd, rs, rt int) {
-	res := new(big.Int).And(vm.R[rs], vm.R[rt])
+	res := wrap(new(big.Int).And(vm.R[rs], vm.R[rt]))
 	vm.R[rd].Set(res)
 	vm.SetFlag(0, res.Sign() == 0)
 }
 
 // Or performs a bitwise OR on R[rs] and R[rt] and stores the result in R[rd].
 func (vm *VM) Or(rd, rs, rt int) {
-	res := new(big.Int).Or(vm.R[rs], vm.R[rt])
+	res := wrap(new(big.Int).Or(vm.R[rs], vm.R[rt]))
 	vm.R[rd].Set(res)
 	vm.SetFlag(0, res.Sign() == 0)
 }
 
 // Xor performs a bitwise XOR on R[rs] and R[rt] and stores the result in R[rd].
 func (vm *VM) Xor(rd, rs, rt int) {
-	res := new(big.Int).Xor(vm.R[rs], vm.R[rt])
+	res := wrap(new(big.Int).Xor(vm.R[rs], vm.R[rt]))
 	vm.R[rd].Set(res)
 	vm.SetFlag(0, res.Sign() == 0)
 }
 
 // Not performs a bitwise NOT on R[rs] and stores the result in R[rd].
 func (vm *VM) Not(rd, rs int) {
-	// Create a mask for 256 bits
-	mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
-
-	// Perform bitwise NOT using XOR with the mask
-	res := new(big.Int).Xor(vm.R[rs], mask)
+	// Perform bitwise NOT using XOR with the 256-bit mask
+	res := new(big.Int).Xor(unsigned(vm.R[rs]), mask256)
 
 	// Store the result in the destination register
 	vm.R[rd].Set(res)
@@ -280,30 +907,33 @@ func (vm *VM) Not(rd, rs int) {
 }
 
 // Lsh performs a logical left shift on R[rs] by n bits and stores the result in R[rd].
+// Bits shifted beyond bit 255 are discarded.
 func (vm *VM) Lsh(rd, rs, n int) {
-	res := new(big.Int).Lsh(vm.R[rs], uint(n))
+	res := wrap(new(big.Int).Lsh(unsigned(vm.R[rs]), uint(n)))
 	vm.R[rd].Set(res)
 	vm.SetFlag(0, res.Sign() == 0)
 }
 
 // Rsh performs a logical right shift on R[rs] by n bits and stores the result in R[rd].
 func (vm *VM) Rsh(rd, rs, n int) {
-	res := new(big.Int).Rsh(vm.R[rs], uint(n))
+	res := new(big.Int).Rsh(unsigned(vm.R[rs]), uint(n))
 	vm.R[rd].Set(res)
 	vm.SetFlag(0, res.Sign() == 0)
 }
 
+// Sar performs an arithmetic right shift on R[rs] by n bits, replicating the sign bit,
+// and stores the result in R[rd].
+func (vm *VM) Sar(rd, rs, n int) {
+	res := wrap(new(big.Int).Rsh(signed(vm.R[rs]), uint(n)))
+	vm.R[rd].Set(res)
+	vm.SetFlag(ZF, res.Sign() == 0)
+}
+
 // Csh performs a cyclic (rotational) shift on R[rs] by n bits and stores the result in R[rd].
-// The immediate value n is treated as signed: positive for left rotation, negative for right rotation.
+// n is taken modulo 256: positive for left rotation, negative for right rotation.
 func (vm *VM) Csh(rd, rs, n int) {
 	// Ensure the register value is represented in exactly 256 bits.
-	data := vm.R[rs].Bytes()
-	if len(data) < 32 {
-		padded := make([]byte, 32)
-		copy(padded[32-len(data):], data)
-		data = padded
-	}
-	orig := new(big.Int).SetBytes(data)
+	orig := unsigned(vm.R[rs])
 
 	// Normalize shift amount (n mod 256)
 	n = n % 256
@@ -317,12 +947,119 @@ func (vm *VM) Csh(rd, rs, n int) {
 	right := new(big.Int).Rsh(orig, uint(256-n))
 	res := new(big.Int).Or(left, right)
 	// Mask to 256 bits.
-	mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
-	res.And(res, mask)
+	res.And(res, mask256)
 	vm.R[rd].Set(res)
 	vm.SetFlag(0, res.Sign() == 0)
 }
 
+// Rol rotates R[rs] left by n bits, modulo 256, and stores the result in R[rd].
+func (vm *VM) Rol(rd, rs, n int) {
+	vm.Csh(rd, rs, n)
+}
+
+// Ror rotates R[rs] right by n bits, modulo 256, and stores the result in R[rd].
+func (vm *VM) Ror(rd, rs, n int) {
+	vm.Csh(rd, rs, -(n % 256))
+}
+
+// shiftCount returns the shift count held in R[rt], its unsigned 256-bit
+// value capped at 256: shifting by 256 or more bits shifts every bit out.
+func (vm *VM) shiftCount(rt int) int {
+	n := unsigned(vm.R[rt])
+	if n.Cmp(big.NewInt(256)) > 0 {
+		return 256
+	}
+	return int(n.Int64())
+}
+
+// ===================================================================
+// Bit Manipulation Instructions
+// ===================================================================
+
+// Popcnt stores the number of set bits in the 256-bit word R[rs] in R[rd].
+// ZF is set if there are none, and CF is cleared.
+func (vm *VM) Popcnt(rd, rs int) {
+	n := 0
+	for _, w := range unsigned(vm.R[rs]).Bits() {
+		n += bits.OnesCount(uint(w))
+	}
+	vm.setCount(rd, n, false)
+}
+
+// Clz stores the number of leading zero bits in the 256-bit word R[rs] in
+// R[rd], 256 if it is zero. ZF is set if the count is zero and CF if R[rs]
+// is zero.
+func (vm *VM) Clz(rd, rs int) {
+	x := unsigned(vm.R[rs])
+	vm.setCount(rd, 256-x.BitLen(), x.Sign() == 0)
+}
+
+// Ctz stores the number of trailing zero bits in the 256-bit word R[rs] in
+// R[rd], 256 if it is zero. ZF is set if the count is zero and CF if R[rs]
+// is zero.
+func (vm *VM) Ctz(rd, rs int) {
+	x := unsigned(vm.R[rs])
+	n := 256
+	if x.Sign() != 0 {
+		n = int(x.TrailingZeroBits())
+	}
+	vm.setCount(rd, n, n == 256)
+}
+
+// setCount stores the bit count n in R[rd], setting ZF if it is zero and CF
+// to carry.
+func (vm *VM) setCount(rd, n int, carry bool) {
+	vm.R[rd].SetInt64(int64(n))
+	vm.SetFlag(ZF, n == 0)
+	vm.SetFlag(CF, carry)
+}
+
+// Bswap reverses the order of the 32 bytes of the 256-bit word R[rs] and
+// stores the result in R[rd]. ZF is set if the result is zero.
+func (vm *VM) Bswap(rd, rs int) {
+	var b [32]byte
+	unsigned(vm.R[rs]).FillBytes(b[:])
+	slices.Reverse(b[:])
+	vm.R[rd].SetBytes(b[:])
+	vm.SetFlag(ZF, vm.R[rd].Sign() == 0)
+}
+
+// Bt copies bit n of the 256-bit word R[rs] into CF. Only the low 8 bits of
+// n are used, so the bit index wraps modulo 256.
+func (vm *VM) Bt(rs, n int) {
+	vm.SetFlag(CF, unsigned(vm.R[rs]).Bit(n&0xFF) == 1)
+}
+
+// Bts copies bit n of R[rs] into CF and stores R[rs] with that bit set in
+// R[rd]. ZF is set if the result is zero.
+func (vm *VM) Bts(rd, rs, n int) {
+	vm.setBit(rd, rs, n, func(uint) uint { return 1 })
+}
+
+// Btr copies bit n of R[rs] into CF and stores R[rs] with that bit cleared in
+// R[rd]. ZF is set if the result is zero.
+func (vm *VM) Btr(rd, rs, n int) {
+	vm.setBit(rd, rs, n, func(uint) uint { return 0 })
+}
+
+// Btc copies bit n of R[rs] into CF and stores R[rs] with that bit inverted
+// in R[rd]. ZF is set if the result is zero.
+func (vm *VM) Btc(rd, rs, n int) {
+	vm.setBit(rd, rs, n, func(b uint) uint { return b ^ 1 })
+}
+
+// setBit stores R[rs] with bit n (modulo 256) replaced by f of its old value
+// in R[rd], copying the old value into CF.
+func (vm *VM) setBit(rd, rs, n int, f func(uint) uint) {
+	n &= 0xFF
+	x := unsigned(vm.R[rs])
+	b := x.Bit(n)
+	res := new(big.Int).SetBit(x, n, f(b))
+	vm.R[rd].Set(res)
+	vm.SetFlag(CF, b == 1)
+	vm.SetFlag(ZF, res.Sign() == 0)
+}
+
 // ===================================================================
 // Control Flow Instructions
 // ===================================================================
@@ -332,6 +1069,21 @@ func (vm *VM) Csh(rd, rs, n int) {
 func (vm *VM) Jump(addr uint32) {
 	vm.J = vm.PC + 1 // Save the next instruction address in J
 	vm.PC = addr     // Set PC to the target address
+	vm.jumped = true
+}
+
+// Branch performs a jump to PC+offset if condition is true. The offset is
+// relative to the branch instruction itself.
+func (vm *VM) Branch(offset int32, condition bool) {
+	vm.JumpIf(vm.PC+uint32(offset), condition)
+}
+
+// Halt stops the machine with the given exit code, leaving PC at the
+// current instruction.
+func (vm *VM) Halt(code int) {
+	vm.State = Halted
+	vm.ExitCode = code
+	vm.jumped = true
 }
 
 // JumpIf performs a jump to addr if condition is true.
@@ -345,69 +1097,170 @@ func (vm *VM) JumpIf(addr uint32, condition bool) {
 // Instruction Execution
 // ===================================================================
 
-// Execute decodes and executes a 24-bit instruction stored in a uint32.
-// The instruction is assumed to be in the following fixed format:
-// [Opcode (8 bits)] [Field1 (4 bits)] [Field2 (4 bits)] [Field3 (4 bits)] [Field4 (4 bits)]
-// For memory instructions, Field4 is reserved (set to 0).
-// For jump instructions, the lower 24 bits represent the target address.
-func (vm *VM) Execute(instruction uint32) {
-	opcode := (instruction >> 24) & 0xFF
-	rd := (instruction >> 20) & 0xF
-	rs := (instruction >> 16) & 0xF
-	rt := (instruction >> 12) & 0xF
-	ax := (instruction >> 8) & 0xF
-	imm := instruction & 0xFF
-
-	switch opcode {
-	case OP_NOP:
-		// No operation
-	case OP_LOAD:
-		vm.Load(int(rd), int(ax))
-	case OP_STORE:
-		vm.Store(int(rs), int(ax))
-	case OP_ADD:
-		vm.Add(int(rd), int(rs), int(rt))
-	case OP_SUB:
-		vm.Sub(int(rd), int(rs), int(rt))
-	case OP_MUL:
-		vm.Mul(int(rd), int(rs), int(rt))
-	case OP_DIV:
-		vm.Div(int(rd), int(rs), int(rt))
-	case OP_MOD:
-		vm.Mod(int(rd), int(rs), int(rt)) // Handle MOD instruction
-	case OP_CMP:
-		vm.Compare(int(rs), int(rt))
-	case OP_ITOF:
-		vm.ITOF(int(rd), int(rs))
-	case OP_FTOI:
-		vm.FTOI(int(rd), int(rs))
-	case OP_AND:
-		vm.And(int(rd), int(rs), int(rt))
-	case OP_OR:
-		vm.Or(int(rd), int(rs), int(rt))
-	case OP_XOR:
-		vm.Xor(int(rd), int(rs), int(rt))
-	case OP_NOT:
-		vm.Not(int(rd), int(rs))
-	case OP_LSH:
-		vm.Lsh(int(rd), int(rs), int(imm))
-	case OP_RSH:
-		vm.Rsh(int(rd), int(rs), int(imm))
-	case OP_CSH:
-		vm.Csh(int(rd), int(rs), int(imm))
-	case OP_JMP:
-		vm.Jump(uint32(instruction & 0x00FFFFFF))
-	case OP_JZ:
-		vm.JumpIf(uint32(instruction&0x00FFFFFF), vm.GetFlag(ZF))
-	case OP_JNZ:
-		vm.JumpIf(uint32(instruction&0x00FFFFFF), !vm.GetFlag(ZF))
-	case OP_JGT:
-		vm.JumpIf(uint32(instruction&0x00FFFFFF), vm.GetFlag(GT))
-	case OP_JLT:
-		vm.JumpIf(uint32(instruction&0x00FFFFFF), vm.GetFlag(LT))
-	case OP_JEQ:
-		vm.JumpIf(uint32(instruction&0x00FFFFFF), vm.GetFlag(ZF))
-	default:
-		fmt.Printf("Unknown opcode: %02X\n", opcode)
+// Execute decodes and executes one 32-bit instruction word. The opcode is
+// in bits 24-31 and the layout of the remaining bits is given by the forms
+// of the opcode in ISA; reserved bits are ignored.
+//
+// If the instruction cannot be executed, Execute returns a *Fault describing
+// it and leaves the machine state unchanged: FaultOpcode for an opcode not in
+// ISA, and FaultRegister if the register fields match none of its forms.
+func (vm *VM) Execute(instruction uint32) (err error) {
+	defer func() {
+		// big.Float operations such as Inf - Inf panic with ErrNaN.
+		if r := recover(); r != nil {
+			if _, ok := r.(big.ErrNaN); !ok {
+				panic(r)
+			}
+			err = &Fault{Kind: FaultFloat}
+		}
+		if f, ok := err.(*Fault); ok {
+			f.PC = vm.PC
+			f.Instruction = instruction
+		}
+	}()
+
+	op := opcodes[instruction>>24]
+	if op == nil {
+		return &Fault{Kind: FaultOpcode}
+	}
+	if !op.Matches(instruction) {
+		return &Fault{Kind: FaultRegister}
+	}
+	return op.exec(vm, Decode(instruction))
+}
+
+// ===================================================================
+// Fetch-Decode-Execute Loop
+// ===================================================================
+
+// LoadProgram copies the program into memory starting at address 0 and
+// resets PC to the first instruction. It fails if the program does not fit.
+func (vm *VM) LoadProgram(program []uint32) error {
+	if uint64(len(program))*InstructionSize > uint64(vm.Memory.Size()) {
+		return errors.New("tmach: program does not fit in memory")
+	}
+	data := make([]byte, len(program)*InstructionSize)
+	for i, instruction := range program {
+		binary.BigEndian.PutUint32(data[i*InstructionSize:], instruction)
+	}
+	vm.Memory.WriteBytes(0, data)
+	vm.PC = 0
+	return nil
+}
+
+// Step fetches the instruction at PC from memory and executes it.
+// PC is advanced to the next instruction unless the instruction jumped.
+// It returns ErrHalted, without executing anything, once the machine has
+// halted. If the instruction faults, PC is left at the instruction and the
+// *Fault is passed to FaultHandler, or returned if there is none; running
+// past the end of memory is a FaultMemory fault. When metering, the gas is
+// charged even if the instruction then faults.
+//
+// Step sets State: Faulted if it returns a fault or other error, Waiting if
+// it returns ErrWait, and otherwise Running, or Halted if the instruction
+// halted the machine. A faulted or waiting machine resumes with the next
+// call to Step.
+func (vm *VM) Step() error {
+	if vm.State == Halted {
+		return ErrHalted
+	}
+	vm.State = Running
+	instruction, ok := vm.fetch(vm.PC)
+	if !ok {
+		addr := min(uint64(vm.PC)*InstructionSize, math.MaxUint32)
+		return vm.fault(&Fault{Kind: FaultMemory, PC: vm.PC, Addr: uint32(addr)})
+	}
+	if vm.Hook != nil {
+		return vm.stepHooked(instruction)
+	}
+	return vm.step(instruction)
+}
+
+// step executes an instruction fetched from PC.
+func (vm *VM) step(instruction uint32) error {
+	vm.jumped = false
+	var err error
+	if vm.Costs != nil {
+		err = vm.charge(instruction)
+	}
+	if err == nil {
+		err = vm.Execute(instruction)
+	}
+	switch {
+	case errors.Is(err, ErrWait):
+		vm.State = Waiting
+		vm.PC++
+		return err
+	case err != nil:
+		var f *Fault
+		if errors.As(err, &f) {
+			return vm.fault(f)
+		}
+		vm.State = Faulted
+		return err
+	}
+	if !vm.jumped {
+		vm.PC++
+	}
+	return nil
+}
+
+// fault passes f to FaultHandler, or returns it if there is none. The
+// machine is Faulted unless the handler handles the fault.
+func (vm *VM) fault(f *Fault) error {
+	err := error(f)
+	if vm.FaultHandler != nil {
+		err = vm.FaultHandler(vm, f)
+	}
+	if err != nil {
+		vm.State = Faulted
+	}
+	return err
+}
+
+// fetch reads the instruction word at instruction address pc.
+// It reports false if the word lies outside memory.
+func (vm *VM) fetch(pc uint32) (uint32, bool) {
+	addr := uint64(pc) * InstructionSize
+	if addr+InstructionSize > uint64(vm.Memory.Size()) {
+		return 0, false
+	}
+	var buf [InstructionSize]byte
+	vm.Memory.ReadBytes(uint32(addr), buf[:])
+	return binary.BigEndian.Uint32(buf[:]), true
+}
+
+// CheckInterval is the number of instructions RunContext executes between
+// checks of its context.
+const CheckInterval = 1024
+
+// Run executes instructions from memory starting at PC until the machine
+// halts, returning nil, or stops with an error: a fault, ErrWait or an error
+// of a system call. ExitCode holds the status of a halted machine.
+func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext is like Run but stops when ctx is done, returning ctx.Err():
+// context.Canceled or context.DeadlineExceeded. The context is checked
+// before the first instruction and every CheckInterval instructions, always
+// between two instructions, so the machine can be resumed from PC by
+// another call to Run, RunContext or Step.
+func (vm *VM) RunContext(ctx context.Context) error {
+	done := ctx.Done()
+	for n := 0; ; n++ {
+		if done != nil && n%CheckInterval == 0 {
+			select {
+			case <-done:
+				return ctx.Err()
+			default:
+			}
+		}
+		if err := vm.Step(); err != nil {
+			if errors.Is(err, ErrHalted) {
+				return nil
+			}
+			return err
+		}
 	}
 }
@@ -1,8 +1,12 @@
 package tmach
 
 import (
+	"context"
+	"errors"
 	"math/big"
+	"slices"
 	"testing"
+	"time"
 )
 
 // TestLoadStore tests the LOAD and STORE instructions.
@@ -141,8 +145,50 @@ func TestCmp(t *testing.T) {
 	if vm.GetFlag(GT) {
 		t.Error("CMP failed: expected GT flag to be clear")
 	}
-	if vm.GetFlag(ZF) {
-		t.Error("CMP failed: expected ZF flag to be clear")
+	if vm.GetFlag(EQ) {
+		t.Error("CMP failed: expected EQ flag to be clear")
+	}
+}
+
+// TestConditionalJumps tests that each conditional jump reads the
+// comparison result, which arithmetic leaves intact.
+func TestConditionalJumps(t *testing.T) {
+	tests := []struct {
+		a, b   int64
+		signed bool
+		taken  []uint32 // Opcodes that jump
+	}{
+		{1, 2, false, []uint32{OP_JLT, OP_JNE, OP_JLE}},
+		{2, 2, false, []uint32{OP_JEQ, OP_JGE, OP_JLE}},
+		{3, 2, false, []uint32{OP_JGT, OP_JNE, OP_JGE}},
+		{-1, 2, false, []uint32{OP_JGT, OP_JNE, OP_JGE}},
+		{-1, 2, true, []uint32{OP_JLT, OP_JNE, OP_JLE}},
+	}
+	for _, tt := range tests {
+		for _, opcode := range []uint32{OP_JEQ, OP_JNE, OP_JGT, OP_JLT, OP_JGE, OP_JLE} {
+			vm := NewVM()
+			vm.R[1].Set(wrap(big.NewInt(tt.a)))
+			vm.R[2].Set(wrap(big.NewInt(tt.b)))
+			cmp := uint32(OP_CMP)
+			if tt.signed {
+				cmp = OP_SCMP
+			}
+			vm.LoadProgram([]uint32{
+				cmp<<24 | 1<<16 | 2<<12,            // CMP R1, R2
+				OP_SUB<<24 | 3<<20 | 3<<16 | 3<<12, // SUB R3, R3, R3 sets ZF
+				opcode<<24 | 0x000010,              // Jcc 0x10
+			})
+			for i := 0; i < 3; i++ {
+				if err := vm.Step(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want := slices.Contains(tt.taken, opcode)
+			if got := vm.PC == 0x10; got != want {
+				t.Errorf("opcode 0x%02X after comparing %d and %d (signed %v): expected jump %v, got %v",
+					opcode, tt.a, tt.b, tt.signed, want, got)
+			}
+		}
 	}
 }
 
@@ -303,6 +349,51 @@ func TestCsh(t *testing.T) {
 	}
 }
 
+// TestShiftRegister tests shifts and rotates by a register count and the
+// ROL and ROR instructions.
+func TestShiftRegister(t *testing.T) {
+	top := new(big.Int).Lsh(big.NewInt(1), 255)
+	huge := new(big.Int).Lsh(big.NewInt(1), 200)
+	tests := []struct {
+		name        string
+		instruction uint32
+		x, n        *big.Int
+		want        *big.Int
+	}{
+		{"LSH", OP_LSHV<<24 | 1<<16 | 2<<12, big.NewInt(1), big.NewInt(255), top},
+		{"LSH 256", OP_LSHV<<24 | 1<<16 | 2<<12, big.NewInt(1), big.NewInt(256), big.NewInt(0)},
+		{"LSH huge", OP_LSHV<<24 | 1<<16 | 2<<12, big.NewInt(1), huge, big.NewInt(0)},
+		{"RSH", OP_RSHV<<24 | 1<<16 | 2<<12, top, big.NewInt(255), big.NewInt(1)},
+		{"RSH negative", OP_RSHV<<24 | 1<<16 | 2<<12, big.NewInt(-1), big.NewInt(-1), big.NewInt(0)},
+		{"SAR", OP_SARV<<24 | 1<<16 | 2<<12, big.NewInt(-8), big.NewInt(2), unsigned(big.NewInt(-2))},
+		{"SAR 300 negative", OP_SARV<<24 | 1<<16 | 2<<12, big.NewInt(-8), big.NewInt(300), mask256},
+		{"SAR 300 positive", OP_SARV<<24 | 1<<16 | 2<<12, big.NewInt(8), big.NewInt(300), big.NewInt(0)},
+		{"ROL", OP_ROL<<24 | 1<<16 | 1, top, nil, big.NewInt(1)},
+		{"ROR", OP_ROR<<24 | 1<<16 | 1, big.NewInt(1), nil, top},
+		{"ROR 255", OP_ROR<<24 | 1<<16 | 255, big.NewInt(1), nil, big.NewInt(2)},
+		{"ROL register", OP_ROLV<<24 | 1<<16 | 2<<12, big.NewInt(3), big.NewInt(256 + 4), big.NewInt(0x30)},
+		{"ROR register", OP_RORV<<24 | 1<<16 | 2<<12, big.NewInt(3), big.NewInt(1), new(big.Int).Add(top, big.NewInt(1))},
+		{"ROR register 256", OP_RORV<<24 | 1<<16 | 2<<12, big.NewInt(3), big.NewInt(256), big.NewInt(3)},
+	}
+	for _, tt := range tests {
+		vm := NewVM()
+		vm.R[1].Set(tt.x)
+		if tt.n != nil {
+			vm.R[2].Set(unsigned(tt.n))
+		}
+		if err := vm.Execute(tt.instruction); err != nil {
+			t.Errorf("%s failed: %v", tt.name, err)
+			continue
+		}
+		if vm.R[0].Cmp(tt.want) != 0 {
+			t.Errorf("%s failed: expected %x, got %x", tt.name, tt.want, vm.R[0])
+		}
+		if vm.GetFlag(ZF) != (tt.want.Sign() == 0) {
+			t.Errorf("%s failed: unexpected ZF", tt.name)
+		}
+	}
+}
+
 // TestJump tests the JMP instruction.
 func TestJump(t *testing.T) {
 	vm := NewVM()
@@ -343,3 +434,919 @@ func TestJumpIf(t *testing.T) {
 		t.Errorf("JZ failed: expected J = 1, got %v", vm.J)
 	}
 }
+
+// TestStep tests fetching and executing instructions from memory.
+func TestStep(t *testing.T) {
+	vm := NewVM()
+
+	vm.R[1].SetInt64(10)
+	vm.R[2].SetInt64(20)
+	vm.LoadProgram([]uint32{
+		OP_ADD<<24 | 0<<20 | 1<<16 | 2<<12, // ADD R0, R1, R2
+		OP_JMP<<24 | 0x000010,              // JMP 0x10
+	})
+
+	if err := vm.Step(); err != nil {
+		t.Fatalf("Step failed: %v", err)
+	}
+	if vm.R[0].Cmp(big.NewInt(30)) != 0 {
+		t.Errorf("Step failed: expected R0 = 30, got %v", vm.R[0])
+	}
+	if vm.PC != 1 {
+		t.Errorf("Step failed: expected PC = 1, got %v", vm.PC)
+	}
+
+	if err := vm.Step(); err != nil {
+		t.Fatalf("Step failed: %v", err)
+	}
+	if vm.PC != 0x10 {
+		t.Errorf("Step failed: expected PC = 0x10, got %v", vm.PC)
+	}
+	if vm.J != 2 {
+		t.Errorf("Step failed: expected J = 2, got %v", vm.J)
+	}
+}
+
+// TestRun tests running a loop until the machine halts.
+func TestRun(t *testing.T) {
+	vm := NewVM()
+
+	vm.R[1].SetInt64(5)
+	vm.R[2].SetInt64(1)
+	vm.LoadProgram([]uint32{
+		OP_ADD<<24 | 0<<20 | 0<<16 | 1<<12, // ADD R0, R0, R1
+		OP_SUB<<24 | 1<<20 | 1<<16 | 2<<12, // SUB R1, R1, R2
+		OP_JNZ<<24 | 0x000000,              // JNZ 0
+		OP_HALT<<24 | 7,                    // HALT 7
+	})
+
+	if err := vm.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	// 5 + 4 + 3 + 2 + 1
+	if vm.R[0].Cmp(big.NewInt(15)) != 0 {
+		t.Errorf("Run failed: expected R0 = 15, got %v", vm.R[0])
+	}
+	if vm.State != Halted || vm.ExitCode != 7 || vm.PC != 3 {
+		t.Errorf("HALT failed: expected to halt at PC 3 with exit code 7, got %v at PC %d with exit code %d", vm.State, vm.PC, vm.ExitCode)
+	}
+	if err := vm.Step(); err != ErrHalted {
+		t.Errorf("Step after halt: expected ErrHalted, got %v", err)
+	}
+}
+
+// TestState tests how the machine state tells apart the ways a run stops.
+func TestState(t *testing.T) {
+	vm := NewVMWithMemory(NewSparseMemory(16))
+	vm.LoadProgram([]uint32{
+		OP_SYSCALL<<24 | 0x100, // SYSCALL 0x100
+		OP_NOP << 24,
+		0xFF000000,
+		OP_NOP << 24,
+	})
+	vm.RegisterSyscall(0x100, func(vm *VM) error { return ErrWait })
+
+	if err := vm.Run(); err != ErrWait || vm.State != Waiting || vm.PC != 1 {
+		t.Fatalf("SYSCALL failed: expected to wait after the SYSCALL, got %v, %v at PC %d", err, vm.State, vm.PC)
+	}
+	var f *Fault
+	if err := vm.Run(); !errors.As(err, &f) || f.Kind != FaultOpcode || vm.State != Faulted || vm.PC != 2 {
+		t.Fatalf("Run failed: expected opcode fault at PC 2, got %v, %v at PC %d", err, vm.State, vm.PC)
+	}
+
+	// Skipping the faulting instruction resumes the machine, which then
+	// runs off the end of memory.
+	vm.PC++
+	if err := vm.Run(); !errors.As(err, &f) || f.Kind != FaultMemory || f.Addr != 16 || vm.State != Faulted {
+		t.Errorf("Run failed: expected memory fault at address 16, got %v, %v", err, vm.State)
+	}
+}
+
+// TestRunContext tests that a cancelled run stops between instructions and
+// can be resumed.
+func TestRunContext(t *testing.T) {
+	vm := NewVM()
+
+	vm.R[1].SetInt64(1)
+	vm.LoadProgram([]uint32{
+		OP_ADD<<24 | 0<<20 | 0<<16 | 1<<12, // ADD R0, R0, R1
+		OP_JMP<<24 | 0x000000,              // JMP 0
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if err := vm.RunContext(ctx); err != context.Canceled {
+		t.Fatalf("RunContext failed: expected context.Canceled, got %v", err)
+	}
+	// R0 counts the iterations completed before the cancellation. The loop
+	// has two instructions and CheckInterval is even, so it stops at ADD.
+	n := vm.R[0].Int64()
+	if n == 0 || vm.PC != 0 {
+		t.Errorf("RunContext failed: expected to stop at PC 0 after some iterations, got PC = %d, R0 = %d", vm.PC, n)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := vm.RunContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("RunContext failed: expected context.DeadlineExceeded, got %v", err)
+	}
+	if vm.R[0].Int64() <= n {
+		t.Errorf("RunContext failed: expected the run to resume from R0 = %d, got %v", n, vm.R[0])
+	}
+
+	// A context that is already done stops the run before any instruction.
+	n = vm.R[0].Int64()
+	if err := vm.RunContext(ctx); err != context.DeadlineExceeded || vm.R[0].Int64() != n {
+		t.Errorf("RunContext failed: expected no instruction to run, got %v, R0 = %v", err, vm.R[0])
+	}
+}
+
+// TestFault tests that invalid instructions raise faults instead of executing.
+func TestFault(t *testing.T) {
+	vm := NewVM()
+
+	vm.PC = 7
+	vm.A[0] = vm.Memory.Size() - 16
+	tests := []struct {
+		instruction uint32
+		kind        FaultKind
+	}{
+		{0xFF000000, FaultOpcode},
+		{OP_LOAD<<24 | 1<<20 | 0<<8, FaultMemory},   // LOAD R1, [A0]
+		{OP_STORE<<24 | 1<<16 | 0<<8, FaultMemory},  // STORE R1, [A0]
+		{OP_LOAD<<24 | 1<<20 | 9<<8, FaultRegister}, // Ax out of range
+		{OP_ADD<<24 | 0<<20 | 1<<16 | 9<<12, FaultRegister},
+		{OP_MOD<<24 | 8<<20 | 9<<16 | 10<<12, FaultRegister},
+		{OP_ITOF<<24 | 0<<20 | 1<<16, FaultRegister},
+	}
+	for _, tt := range tests {
+		err := vm.Execute(tt.instruction)
+		f, ok := err.(*Fault)
+		if !ok {
+			t.Errorf("%08X: expected fault, got %v", tt.instruction, err)
+			continue
+		}
+		if f.Kind != tt.kind || f.PC != 7 || f.Instruction != tt.instruction {
+			t.Errorf("%08X: unexpected fault %v", tt.instruction, f)
+		}
+	}
+
+	// Inf - Inf has no big.Float result.
+	vm.F[1].SetInf(false)
+	vm.F[2].SetInf(false)
+	if f, ok := vm.Execute(OP_SUB<<24 | 8<<20 | 9<<16 | 10<<12).(*Fault); !ok || f.Kind != FaultFloat {
+		t.Errorf("expected FaultFloat, got %v", f)
+	}
+}
+
+// TestFaultHandler tests handing faults raised by Step to a handler.
+func TestFaultHandler(t *testing.T) {
+	vm := NewVM()
+	vm.LoadProgram([]uint32{
+		0xFF000000,                         // invalid
+		OP_ADD<<24 | 0<<20 | 1<<16 | 2<<12, // ADD R0, R1, R2
+	})
+
+	if f, ok := vm.Step().(*Fault); !ok || f.Kind != FaultOpcode || vm.PC != 0 {
+		t.Fatalf("expected unhandled fault at PC 0, got %v at PC %v", f, vm.PC)
+	}
+
+	var faults int
+	vm.FaultHandler = func(vm *VM, f *Fault) error {
+		faults++
+		vm.PC++ // skip the faulting instruction
+		return nil
+	}
+	vm.R[1].SetInt64(1)
+	vm.R[2].SetInt64(2)
+	for i := 0; i < 2; i++ {
+		if err := vm.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if faults != 1 || vm.R[0].Cmp(big.NewInt(3)) != 0 {
+		t.Errorf("expected 1 fault and R0 = 3, got %d and %v", faults, vm.R[0])
+	}
+}
+
+// TestLoadImm tests the LDI instruction.
+func TestLoadImm(t *testing.T) {
+	vm := NewVM()
+
+	vm.Execute(OP_LDI<<24 | 1<<20 | 0xABCDE) // LDI R1, 0xABCDE
+	vm.Execute(OP_LDI<<24 | 9<<20 | 3)       // LDI F1, 3
+	vm.Execute(OP_LDA<<24 | 2<<20 | 0x1000)  // LDI A2, 0x1000
+
+	if vm.R[1].Cmp(big.NewInt(0xABCDE)) != 0 {
+		t.Errorf("LDI failed: expected R1 = 0xABCDE, got %v", vm.R[1])
+	}
+	if vm.F[1].Cmp(big.NewFloat(3)) != 0 {
+		t.Errorf("LDI failed: expected F1 = 3, got %v", vm.F[1])
+	}
+	if vm.A[2] != 0x1000 {
+		t.Errorf("LDI failed: expected A2 = 0x1000, got %#x", vm.A[2])
+	}
+}
+
+// TestLoadWide tests the multi-word LDW instruction.
+func TestLoadWide(t *testing.T) {
+	vm := NewVM()
+
+	vm.LoadProgram([]uint32{
+		OP_LDW<<24 | 1<<20 | (3 - 1), // LDW R1, 3
+		0x00000001, 0x00000002, 0x00000003,
+		OP_LDI<<24 | 2<<20 | 7, // LDI R2, 7
+	})
+	for i := 0; i < 2; i++ {
+		if err := vm.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, _ := new(big.Int).SetString("0x000000010000000200000003", 0)
+	if vm.R[1].Cmp(expected) != 0 {
+		t.Errorf("LDW failed: expected R1 = %x, got %x", expected, vm.R[1])
+	}
+	if vm.R[2].Cmp(big.NewInt(7)) != 0 || vm.PC != 5 {
+		t.Errorf("LDW failed: literal words not skipped, R2 = %v, PC = %v", vm.R[2], vm.PC)
+	}
+}
+
+// TestMove tests MOV between the R, F and A register files.
+func TestMove(t *testing.T) {
+	vm := NewVM()
+
+	vm.R[1].SetInt64(0x1234)
+	vm.F[1].SetFloat64(1.5)
+	vm.Execute(OP_MOV<<24 | 2<<20 | 1<<16)  // MOV R2, R1
+	vm.Execute(OP_MOV<<24 | 10<<20 | 9<<16) // MOV F2, F1
+	vm.Execute(OP_RTOA<<24 | 3<<20 | 1<<16) // MOV A3, R1
+	vm.Execute(OP_MOVA<<24 | 4<<20 | 3<<16) // MOV A4, A3
+	vm.Execute(OP_ATOR<<24 | 5<<20 | 4<<16) // MOV R5, A4
+
+	if vm.R[2].Cmp(vm.R[1]) != 0 || vm.F[2].Cmp(vm.F[1]) != 0 {
+		t.Errorf("MOV failed: got R2 = %v, F2 = %v", vm.R[2], vm.F[2])
+	}
+	if vm.A[3] != 0x1234 || vm.A[4] != 0x1234 || vm.R[5].Cmp(vm.R[1]) != 0 {
+		t.Errorf("MOV failed: got A3 = %#x, A4 = %#x, R5 = %v", vm.A[3], vm.A[4], vm.R[5])
+	}
+
+	// Between R and F, MOV moves the binary256 bits.
+	if err := vm.Execute(OP_MOV<<24 | 2<<20 | 9<<16); err != nil { // MOV R2, F1
+		t.Fatalf("MOV R2, F1 failed: %v", err)
+	}
+	b := EncodeFloat256(vm.F[1])
+	if want := new(big.Int).SetBytes(b[:]); vm.R[2].Cmp(want) != 0 {
+		t.Errorf("MOV R2, F1 failed: expected R2 = %x, got %x", want, vm.R[2])
+	}
+	vm.Execute(OP_MOV<<24 | 11<<20 | 2<<16) // MOV F3, R2
+	if vm.F[3].Cmp(vm.F[1]) != 0 {
+		t.Errorf("MOV F3, R2 failed: expected F3 = %v, got %v", vm.F[1], vm.F[3])
+	}
+
+	// All ones is a NaN.
+	vm.R[4].Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	var f *Fault
+	if err := vm.Execute(OP_MOV<<24 | 11<<20 | 4<<16); !errors.As(err, &f) || f.Kind != FaultFloat { // MOV F3, R4
+		t.Errorf("MOV F3, R4: expected float fault, got %v", err)
+	}
+	if vm.F[3].Cmp(vm.F[1]) != 0 {
+		t.Errorf("MOV F3, R4: expected F3 unchanged, got %v", vm.F[3])
+	}
+}
+
+// TestAddAddr tests address arithmetic.
+func TestAddAddr(t *testing.T) {
+	vm := NewVM()
+
+	vm.A[1] = 0x100
+	vm.R[2].SetInt64(0x40)
+	vm.Execute(OP_ADDA<<24 | 1<<20 | 1<<16 | 32)     // ADD A1, A1, 32
+	vm.Execute(OP_ADDA<<24 | 2<<20 | 1<<16 | 0xFFFF) // ADD A2, A1, -1
+	vm.Execute(OP_ADDAR<<24 | 3<<20 | 1<<16 | 2<<12) // ADD A3, A1, R2
+
+	if vm.A[1] != 0x120 || vm.A[2] != 0x11F || vm.A[3] != 0x160 {
+		t.Errorf("ADD A failed: got A1 = %#x, A2 = %#x, A3 = %#x", vm.A[1], vm.A[2], vm.A[3])
+	}
+}
+
+// TestPushPop tests the PUSH and POP instructions.
+func TestPushPop(t *testing.T) {
+	vm := NewVM()
+
+	vm.R[1].SetInt64(123)
+	vm.A[1] = 0xCAFE
+	vm.Push(1)     // PUSH R1
+	vm.PushAddr(1) // PUSH A1
+	if vm.SP != vm.StackBase-36 {
+		t.Errorf("PUSH failed: expected SP = %#x, got %#x", vm.StackBase-36, vm.SP)
+	}
+
+	vm.PopAddr(2) // POP A2
+	vm.Pop(2)     // POP R2
+	if vm.A[2] != 0xCAFE || vm.R[2].Cmp(vm.R[1]) != 0 {
+		t.Errorf("POP failed: got A2 = %#x, R2 = %v", vm.A[2], vm.R[2])
+	}
+	if vm.SP != vm.StackBase {
+		t.Errorf("POP failed: expected SP = %#x, got %#x", vm.StackBase, vm.SP)
+	}
+}
+
+// TestCallRet tests the CALL and RET instructions.
+func TestCallRet(t *testing.T) {
+	vm := NewVM()
+
+	vm.PC = 0x10
+	vm.Execute(OP_CALL<<24 | 0x100) // CALL 0x100
+	if vm.PC != 0x100 {
+		t.Errorf("CALL failed: expected PC = 0x100, got %#x", vm.PC)
+	}
+	vm.Execute(OP_CALL<<24 | 0x200) // CALL 0x200
+	vm.Execute(OP_RET << 24)        // RET
+	if vm.PC != 0x101 {
+		t.Errorf("RET failed: expected PC = 0x101, got %#x", vm.PC)
+	}
+	vm.Execute(OP_RET << 24) // RET
+	if vm.PC != 0x11 {
+		t.Errorf("RET failed: expected PC = 0x11, got %#x", vm.PC)
+	}
+}
+
+// TestStackFaults tests stack overflow and underflow.
+func TestStackFaults(t *testing.T) {
+	vm := NewVM()
+
+	if f, ok := vm.Execute(OP_RET << 24).(*Fault); !ok || f.Kind != FaultStackUnderflow {
+		t.Errorf("expected stack underflow, got %v", f)
+	}
+
+	vm.SP = vm.StackLimit + 16
+	if f, ok := vm.Execute(OP_PUSH << 24).(*Fault); !ok || f.Kind != FaultStackOverflow {
+		t.Errorf("expected stack overflow, got %v", f)
+	}
+	if vm.SP != vm.StackLimit+16 {
+		t.Errorf("faulting PUSH changed SP to %#x", vm.SP)
+	}
+}
+
+// TestWraparound tests that integer results wrap around modulo 2^256.
+func TestWraparound(t *testing.T) {
+	vm := NewVM()
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	maxInt := new(big.Int).Rsh(max, 1)
+
+	// max + 1 = 0 with carry, no signed overflow (-1 + 1).
+	vm.R[1].Set(max)
+	vm.R[2].SetInt64(1)
+	vm.Add(0, 1, 2)
+	if vm.R[0].Sign() != 0 || !vm.GetFlag(CF) || !vm.GetFlag(ZF) || vm.GetFlag(OF) {
+		t.Errorf("ADD failed: got %v, SR = %08b", vm.R[0], vm.SR)
+	}
+
+	// maxInt + 1 overflows as signed but not as unsigned.
+	vm.R[1].Set(maxInt)
+	vm.Add(0, 1, 2)
+	if vm.R[0].Cmp(new(big.Int).Add(maxInt, big.NewInt(1))) != 0 || vm.GetFlag(CF) || !vm.GetFlag(OF) {
+		t.Errorf("ADD failed: got %v, SR = %08b", vm.R[0], vm.SR)
+	}
+
+	// 0 - 1 = 2^256 - 1 with borrow.
+	vm.R[1].SetInt64(0)
+	vm.Sub(0, 1, 2)
+	if vm.R[0].Cmp(max) != 0 || !vm.GetFlag(CF) || vm.GetFlag(OF) {
+		t.Errorf("SUB failed: got %v, SR = %08b", vm.R[0], vm.SR)
+	}
+	if vm.Signed(0).Int64() != -1 {
+		t.Errorf("SUB failed: expected signed -1, got %v", vm.Signed(0))
+	}
+
+	// 2^255 * 2 = 0 with carry and signed overflow.
+	vm.R[1].Lsh(big.NewInt(1), 255)
+	vm.R[2].SetInt64(2)
+	vm.Mul(0, 1, 2)
+	if vm.R[0].Sign() != 0 || !vm.GetFlag(CF) || !vm.GetFlag(OF) {
+		t.Errorf("MUL failed: got %v, SR = %08b", vm.R[0], vm.SR)
+	}
+
+	// Shifts discard bits beyond bit 255.
+	vm.R[1].Set(max)
+	vm.Lsh(0, 1, 4)
+	if vm.R[0].Cmp(new(big.Int).Sub(max, big.NewInt(15))) != 0 {
+		t.Errorf("LSH failed: got %x", vm.R[0])
+	}
+}
+
+// TestSignedArithmetic tests the signed variants of DIV, MOD, CMP and RSH.
+func TestSignedArithmetic(t *testing.T) {
+	vm := NewVM()
+	minusSeven := wrap(big.NewInt(-7))
+
+	vm.R[1].Set(minusSeven)
+	vm.R[2].SetInt64(2)
+	vm.SDiv(0, 1, 2)
+	if vm.Signed(0).Int64() != -3 {
+		t.Errorf("SDIV failed: expected -3, got %v", vm.Signed(0))
+	}
+	vm.SMod(0, 1, 2)
+	if vm.Signed(0).Int64() != -1 {
+		t.Errorf("SMOD failed: expected -1, got %v", vm.Signed(0))
+	}
+
+	// Unsigned, -7 is a huge number.
+	vm.Div(0, 1, 2)
+	if vm.R[0].Cmp(new(big.Int).Rsh(minusSeven, 1)) != 0 {
+		t.Errorf("DIV failed: got %v", vm.R[0])
+	}
+
+	vm.SCompare(1, 2)
+	if !vm.GetFlag(LT) || vm.GetFlag(GT) {
+		t.Error("SCMP failed: expected -7 < 2")
+	}
+	vm.Compare(1, 2)
+	if vm.GetFlag(LT) || !vm.GetFlag(GT) {
+		t.Error("CMP failed: expected unsigned -7 > 2")
+	}
+
+	vm.Sar(0, 1, 1)
+	if vm.Signed(0).Int64() != -4 {
+		t.Errorf("SAR failed: expected -4, got %v", vm.Signed(0))
+	}
+	vm.Rsh(0, 1, 255)
+	if vm.R[0].Int64() != 1 {
+		t.Errorf("RSH failed: expected 1, got %v", vm.R[0])
+	}
+
+	// -2^255 / -1 overflows.
+	vm.R[1].Lsh(big.NewInt(1), 255)
+	vm.R[2].Set(wrap(big.NewInt(-1)))
+	vm.SDiv(0, 1, 2)
+	if vm.R[0].Cmp(vm.R[1]) != 0 || !vm.GetFlag(OF) {
+		t.Errorf("SDIV failed: got %v, SR = %08b", vm.R[0], vm.SR)
+	}
+}
+
+// TestSignedStore tests that negative values round-trip through memory.
+func TestSignedStore(t *testing.T) {
+	vm := NewVM()
+
+	vm.F[0].SetFloat64(-42.5)
+	vm.FTOI(1, 0) // R1 = -42
+	vm.A[0] = 0x1000
+	vm.Store(1, 0)
+	vm.Load(2, 0)
+	if vm.Signed(2).Int64() != -42 {
+		t.Errorf("expected -42 after STORE/LOAD, got %v", vm.Signed(2))
+	}
+
+	vm.ITOF(1, 2)
+	if vm.F[1].Cmp(big.NewFloat(-42)) != 0 {
+		t.Errorf("ITOF failed: expected -42, got %v", vm.F[1])
+	}
+}
+
+// recordHook records the calls made to a Hook.
+type recordHook struct {
+	before []Inst
+	deltas []Delta
+}
+
+func (h *recordHook) Before(vm *VM, in Inst) { h.before = append(h.before, in) }
+
+func (h *recordHook) After(vm *VM, in Inst, d *Delta) {
+	c := *d
+	c.R = append([]IntDelta(nil), d.R...)
+	for i := range c.R {
+		c.R[i].Old = new(big.Int).Set(d.R[i].Old)
+		c.R[i].New = new(big.Int).Set(d.R[i].New)
+	}
+	h.deltas = append(h.deltas, c)
+}
+
+// TestHook tests that a hook observes each instruction and its effects.
+func TestHook(t *testing.T) {
+	vm := NewVM()
+	h := &recordHook{}
+	vm.Hook = h
+
+	vm.R[0].SetInt64(1)
+	vm.R[1].SetInt64(1)
+	vm.LoadProgram([]uint32{
+		OP_SUB<<24 | 0<<20 | 0<<16 | 1<<12, // SUB R0, R0, R1
+		OP_JZ<<24 | 0x000003,               // JZ 3
+		OP_NOP << 24,
+		0xFF000000,
+	})
+	for i := 0; i < 3; i++ {
+		vm.Step()
+	}
+
+	if len(h.before) != 3 || len(h.deltas) != 3 {
+		t.Fatalf("Hook failed: expected 3 calls, got %d and %d", len(h.before), len(h.deltas))
+	}
+	if in := h.before[0]; in.PC != 0 || in.Opcode != OP_SUB || in.Rd != 0 || in.Rs != 0 || in.Rt != 1 {
+		t.Errorf("Hook failed: unexpected decoding of SUB: %+v", in)
+	}
+	d := h.deltas[0]
+	if len(d.R) != 1 || d.R[0].Reg != 0 || d.R[0].Old.Int64() != 1 || d.R[0].New.Sign() != 0 {
+		t.Errorf("Hook failed: expected R0 1->0, got %+v", d.R)
+	}
+	if d.OldSR != 0 || d.SR != 1<<ZF || d.PC != 1 || d.Err != nil {
+		t.Errorf("Hook failed: unexpected SUB delta %+v", d)
+	}
+	if in := h.before[1]; in.PC != 1 || in.Addr != 3 {
+		t.Errorf("Hook failed: unexpected decoding of JZ: %+v", in)
+	}
+	if d := h.deltas[1]; d.PC != 3 || d.J != 2 || len(d.R) != 0 {
+		t.Errorf("Hook failed: unexpected JZ delta %+v", d)
+	}
+	if d := h.deltas[2]; d.PC != 3 || d.Err == nil {
+		t.Errorf("Hook failed: expected fault at 3, got %+v", d)
+	}
+}
+
+// TestIndirectJumps tests JMP through an address register, the return through
+// J, and relative branches.
+func TestIndirectJumps(t *testing.T) {
+	vm := NewVM()
+	vm.LoadProgram([]uint32{
+		OP_LDA<<24 | 1<<20 | 5,             // LDI A1, 5
+		OP_JMPA<<24 | 1<<16,                // JMP A1
+		OP_LDI<<24 | 3<<20 | 3,             // LDI R3, 3
+		OP_BRA<<24 | 4,                     // BRA 4
+		OP_NOP << 24,                       //
+		OP_LDI<<24 | 2<<20 | 42,            // LDI R2, 42
+		OP_JMPJ << 24,                      // JMP J
+		OP_SUB<<24 | 3<<20 | 3<<16 | 1<<12, // SUB R3, R3, R1
+		OP_BNZ<<24 | 0xFFFFFF,              // BNZ -1
+		OP_HALT << 24,                      // HALT
+	})
+	vm.R[1].SetInt64(1)
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if vm.R[2].Int64() != 42 || vm.R[3].Sign() != 0 {
+		t.Errorf("JMP failed: expected R2 = 42 and R3 = 0, got %v and %v", vm.R[2], vm.R[3])
+	}
+	if vm.PC != 9 || vm.J != 9 {
+		t.Errorf("BNZ failed: expected to halt at 9 with J = 9, got PC = %d, J = %d", vm.PC, vm.J)
+	}
+
+	var f *Fault
+	if err := vm.Execute(OP_JMPA<<24 | 8<<16); !errors.As(err, &f) || f.Kind != FaultRegister {
+		t.Errorf("JMP failed: expected register fault for A8, got %v", err)
+	}
+}
+
+// TestFPCR tests reading and writing the floating-point control register.
+func TestFPCR(t *testing.T) {
+	vm := NewVM()
+	vm.LoadProgram([]uint32{
+		OP_GETFPCR<<24 | 0<<20,              // MOV R0, FPCR
+		OP_SETFPCR<<24 | 1<<16,              // MOV FPCR, R1
+		OP_ITOF<<24 | 8<<20 | 2<<16,         // ITOF F0, R2
+		OP_DIV<<24 | 8<<20 | 8<<16 | 11<<12, // DIV F0, F0, F3
+		OP_HALT << 24,                       // HALT
+	})
+	vm.R[1].SetUint64(uint64(FPControl(24, big.ToPositiveInf)))
+	vm.R[2].SetInt64(1)
+	vm.F[3].SetInt64(3)
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if vm.R[0].Uint64() != DefaultFPCR {
+		t.Errorf("MOV Rd, FPCR failed: expected 0x%08X, got 0x%X", DefaultFPCR, vm.R[0])
+	}
+	// 1/3 rounded up to 24 bits.
+	want := new(big.Float).SetPrec(24).SetMode(big.ToPositiveInf).Quo(big.NewFloat(1), big.NewFloat(3))
+	if vm.F[0].Prec() != 24 || vm.F[0].Cmp(want) != 0 || vm.F[0].Cmp(big.NewFloat(1.0/3)) <= 0 {
+		t.Errorf("DIV failed: expected %v at 24 bits, got %v at %d bits", want, vm.F[0], vm.F[0].Prec())
+	}
+	if !vm.GetFPFlag(IX) || vm.FPCR != FPControl(24, big.ToPositiveInf)|1<<IX {
+		t.Errorf("DIV failed: expected FPCR 0x%08X, got 0x%08X", FPControl(24, big.ToPositiveInf)|1<<IX, vm.FPCR)
+	}
+
+	// Invalid rounding modes, precisions out of range and reserved bits fault.
+	for _, v := range []uint32{FPControl(FloatPrec, 6), FPControl(0, big.ToZero), FPControl(MaxFloatPrec+1, big.ToZero),
+		DefaultFPCR | 1<<8} {
+		vm.R[1].SetUint64(uint64(v))
+		var f *Fault
+		if err := vm.Execute(OP_SETFPCR<<24 | 1<<16); !errors.As(err, &f) || f.Kind != FaultFloat {
+			t.Errorf("MOV FPCR, Rs of 0x%08X failed: expected floating-point fault, got %v", v, err)
+		}
+	}
+	if vm.FPCR != FPControl(24, big.ToPositiveInf)|1<<IX {
+		t.Errorf("MOV FPCR, Rs failed: FPCR changed to 0x%08X", vm.FPCR)
+	}
+}
+
+// TestFTOIRounding tests that FTOI rounds in the FPCR rounding mode.
+func TestFTOIRounding(t *testing.T) {
+	tests := []struct {
+		mode     big.RoundingMode
+		pos, neg int64 // 2.5 and -2.5 converted
+	}{
+		{big.ToNearestEven, 2, -2},
+		{big.ToNearestAway, 3, -3},
+		{big.ToZero, 2, -2},
+		{big.AwayFromZero, 3, -3},
+		{big.ToNegativeInf, 2, -3},
+		{big.ToPositiveInf, 3, -2},
+	}
+	for _, tt := range tests {
+		vm := NewVM()
+		vm.FPCR = FPControl(FloatPrec, tt.mode)
+		vm.F[1].SetFloat64(2.5)
+		vm.F[2].SetFloat64(-2.5)
+		vm.FTOI(0, 1)
+		vm.FTOI(1, 2)
+		if vm.R[0].Int64() != tt.pos || signed(vm.R[1]).Int64() != tt.neg {
+			t.Errorf("FTOI failed in %v: expected %d and %d, got %v and %v", tt.mode, tt.pos, tt.neg, vm.R[0], signed(vm.R[1]))
+		}
+		if !vm.GetFPFlag(IX) {
+			t.Errorf("FTOI failed in %v: expected IX", tt.mode)
+		}
+	}
+}
+
+// TestFTOIHuge tests that FTOI keeps the low 256 bits of integers far beyond
+// the 256-bit range.
+func TestFTOIHuge(t *testing.T) {
+	pow := func(e int) *big.Float { return new(big.Float).SetMantExp(big.NewFloat(1), e) }
+	tests := []struct {
+		name string
+		x    *big.Float
+		want *big.Int
+	}{
+		{"2^300 + 2^250", new(big.Float).Add(pow(300), pow(250)), new(big.Int).Lsh(big.NewInt(1), 250)},
+		{"-(2^300 + 2^250)", new(big.Float).Neg(new(big.Float).Add(pow(300), pow(250))),
+			wrap(new(big.Int).Lsh(big.NewInt(-1), 250))},
+		{"2^(2^30)", pow(1 << 30), big.NewInt(0)},
+		{"-2^(2^30)", new(big.Float).Neg(pow(1 << 30)), big.NewInt(0)},
+	}
+	for _, tt := range tests {
+		vm := NewVM()
+		vm.R[0].SetInt64(42)
+		vm.F[1].Set(tt.x)
+		vm.FTOI(0, 1)
+		if vm.R[0].Cmp(tt.want) != 0 || !vm.GetFlag(OF) || vm.GetFPFlag(IX) {
+			t.Errorf("FTOI of %s failed: expected %x with OF, got %x, SR = 0x%02X, FPCR = 0x%08X",
+				tt.name, tt.want, vm.R[0], vm.SR, vm.FPCR)
+		}
+	}
+}
+
+// TestFPFlags tests the inexact, underflow and overflow flags.
+func TestFPFlags(t *testing.T) {
+	huge := new(big.Float).SetMantExp(big.NewFloat(1), big.MaxExp-1)
+	tiny := new(big.Float).SetMantExp(big.NewFloat(1), big.MinExp)
+	tests := []struct {
+		name   string
+		opcode uint32
+		x, y   *big.Float
+		flags  uint32
+	}{
+		{"ADD 1, 2", OP_ADD, big.NewFloat(1), big.NewFloat(2), 0},
+		{"ADD 1, 2^-300", OP_ADD, big.NewFloat(1), new(big.Float).SetMantExp(big.NewFloat(1), -300), 1 << IX},
+		{"DIV 1, 3", OP_DIV, big.NewFloat(1), big.NewFloat(3), 1 << IX},
+		{"SQRT 16", OP_SQRT, big.NewFloat(16), nil, 0},
+		{"SQRT 2", OP_SQRT, big.NewFloat(2), nil, 1 << IX},
+		{"MUL huge, huge", OP_MUL, huge, huge, 1<<IX | 1<<OV},
+		{"MUL tiny, tiny", OP_MUL, tiny, tiny, 1<<IX | 1<<UF},
+		{"EXP 1e10", OP_EXP, big.NewFloat(1e10), nil, 1<<IX | 1<<OV},
+		{"EXP -1e10", OP_EXP, big.NewFloat(-1e10), nil, 1<<IX | 1<<UF},
+		{"POW 2, 2^40", OP_POW, big.NewFloat(2), big.NewFloat(1 << 40), 1<<IX | 1<<OV},
+		{"POW -2, 2^40+1", OP_POW, big.NewFloat(-2), big.NewFloat(1<<40 + 1), 1<<IX | 1<<OV},
+		{"POW 0, -1", OP_POW, big.NewFloat(0), big.NewFloat(-1), 0},
+	}
+	for _, tt := range tests {
+		vm := NewVM()
+		vm.F[1].Set(tt.x)
+		if tt.y != nil {
+			vm.F[2].Set(tt.y)
+		}
+		if err := vm.Execute(tt.opcode<<24 | 8<<20 | 9<<16 | 10<<12); err != nil {
+			t.Errorf("%s failed: %v", tt.name, err)
+			continue
+		}
+		if got := vm.FPCR &^ DefaultFPCR; got != tt.flags {
+			t.Errorf("%s failed: expected flags 0x%02X, got 0x%02X", tt.name, tt.flags, got)
+		}
+	}
+
+	// Flags are sticky until FPCR is written.
+	vm := NewVM()
+	vm.F[1].SetInt64(1)
+	vm.F[2].SetInt64(3)
+	vm.Div(8, 9, 10)
+	vm.Add(8, 9, 10)
+	if !vm.GetFPFlag(IX) {
+		t.Errorf("ADD failed: IX cleared")
+	}
+	vm.SetFPCR(DefaultFPCR)
+	if vm.GetFPFlag(IX) {
+		t.Errorf("SetFPCR failed: IX not cleared")
+	}
+}
+
+// TestStoreRounding tests that STORE rounds F registers of more than FloatPrec
+// bits in the FPCR rounding mode and raises the FPCR flags.
+func TestStoreRounding(t *testing.T) {
+	vm := NewVM()
+	vm.FPCR = FPControl(300, big.ToPositiveInf)
+	vm.A[0] = 0x1000
+	x := new(big.Float).SetPrec(300).SetMantExp(big.NewFloat(1), -280)
+	vm.F[1].SetPrec(300).Add(big.NewFloat(1), x)
+	vm.Store(9, 0)
+	if !vm.GetFPFlag(IX) || vm.GetFPFlag(OV) || vm.GetFPFlag(UF) {
+		t.Errorf("STORE failed: expected IX alone, got FPCR 0x%08X", vm.FPCR)
+	}
+	vm.Load(8, 0)
+	if vm.F[0].Cmp(big.NewFloat(1)) <= 0 || vm.F[0].Cmp(vm.F[1]) < 0 {
+		t.Errorf("STORE failed: expected %v rounded up, got %v", vm.F[1], vm.F[0])
+	}
+
+	vm.SetFPCR(DefaultFPCR)
+	vm.F[1].SetMantExp(big.NewFloat(1), float256EMax+1)
+	vm.Store(9, 0)
+	if !vm.GetFPFlag(IX) || !vm.GetFPFlag(OV) {
+		t.Errorf("STORE failed: expected IX and OV, got FPCR 0x%08X", vm.FPCR)
+	}
+	vm.SetFPCR(DefaultFPCR)
+	vm.F[1].SetMantExp(big.NewFloat(3), float256EMin-FloatPrec-2)
+	vm.Store(9, 0)
+	if !vm.GetFPFlag(IX) || !vm.GetFPFlag(UF) {
+		t.Errorf("STORE failed: expected IX and UF, got FPCR 0x%08X", vm.FPCR)
+	}
+}
+
+// TestModularArithmetic tests ADDMOD, SUBMOD, MULMOD, EXPMOD and INVMOD.
+func TestModularArithmetic(t *testing.T) {
+	// The secp256k1 field prime, 2^256 - 2^32 - 977, whose sums and products
+	// overflow 256 bits.
+	p, _ := new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F", 16)
+	pm1 := new(big.Int).Sub(p, big.NewInt(1))
+	pm2 := new(big.Int).Sub(p, big.NewInt(2))
+	tests := []struct {
+		name    string
+		opcode  uint32
+		a, b, m *big.Int
+		want    *big.Int
+	}{
+		{"ADDMOD", OP_ADDMOD, pm1, pm1, p, pm2},
+		{"SUBMOD", OP_SUBMOD, big.NewInt(3), big.NewInt(5), p, pm2},
+		{"MULMOD", OP_MULMOD, pm1, pm1, p, big.NewInt(1)},
+		{"EXPMOD", OP_EXPMOD, big.NewInt(3), big.NewInt(5), big.NewInt(7), big.NewInt(5)},
+		{"EXPMOD Fermat", OP_EXPMOD, big.NewInt(12345), pm1, p, big.NewInt(1)},
+		{"EXPMOD zero", OP_EXPMOD, big.NewInt(7), big.NewInt(3), big.NewInt(7), big.NewInt(0)},
+		{"INVMOD", OP_INVMOD, big.NewInt(3), big.NewInt(7), nil, big.NewInt(5)},
+		{"INVMOD p", OP_INVMOD, pm1, p, nil, pm1},
+	}
+	for _, tt := range tests {
+		vm := NewVM()
+		vm.R[1].Set(tt.a)
+		vm.R[2].Set(tt.b)
+		if tt.m != nil {
+			vm.R[3].Set(tt.m)
+		}
+		if err := vm.Execute(tt.opcode<<24 | 0<<20 | 1<<16 | 2<<12 | 3<<8); err != nil {
+			t.Errorf("%s failed: %v", tt.name, err)
+			continue
+		}
+		if vm.R[0].Cmp(tt.want) != 0 {
+			t.Errorf("%s failed: expected %v, got %v", tt.name, tt.want, vm.R[0])
+		}
+		if vm.GetFlag(ZF) != (tt.want.Sign() == 0) {
+			t.Errorf("%s failed: unexpected ZF", tt.name)
+		}
+	}
+
+	// A zero modulus sets DF and a missing inverse faults, leaving R0 unchanged.
+	vm := NewVM()
+	vm.R[0].SetInt64(42)
+	vm.R[1].SetInt64(4)
+	vm.MulMod(0, 1, 1, 3)
+	if !vm.GetFlag(DF) || vm.R[0].Int64() != 42 {
+		t.Errorf("MULMOD failed: expected DF and R0 = 42, got SR = 0x%02X and R0 = %v", vm.SR, vm.R[0])
+	}
+	vm.R[2].SetInt64(8)
+	var f *Fault
+	if err := vm.Execute(OP_INVMOD<<24 | 0<<20 | 1<<16 | 2<<12); !errors.As(err, &f) || f.Kind != FaultArithmetic {
+		t.Errorf("INVMOD failed: expected arithmetic fault, got %v", err)
+	}
+	if vm.R[0].Int64() != 42 {
+		t.Errorf("INVMOD failed: R0 changed to %v", vm.R[0])
+	}
+}
+
+// TestBitCounts tests POPCNT, CLZ and CTZ over the full 256-bit word.
+func TestBitCounts(t *testing.T) {
+	ones := new(big.Int).Set(mask256)
+	top := new(big.Int).Lsh(big.NewInt(1), 255)
+	tests := []struct {
+		name   string
+		opcode uint32
+		x      *big.Int
+		want   int64
+		zf, cf bool
+	}{
+		{"POPCNT", OP_POPCNT, big.NewInt(0xF0F1), 9, false, false},
+		{"POPCNT all ones", OP_POPCNT, ones, 256, false, false},
+		{"POPCNT negative", OP_POPCNT, big.NewInt(-2), 255, false, false},
+		{"POPCNT zero", OP_POPCNT, big.NewInt(0), 0, true, false},
+		{"CLZ", OP_CLZ, big.NewInt(1), 255, false, false},
+		{"CLZ top", OP_CLZ, top, 0, true, false},
+		{"CLZ zero", OP_CLZ, big.NewInt(0), 256, false, true},
+		{"CTZ", OP_CTZ, big.NewInt(0x100), 8, false, false},
+		{"CTZ top", OP_CTZ, top, 255, false, false},
+		{"CTZ odd", OP_CTZ, big.NewInt(3), 0, true, false},
+		{"CTZ zero", OP_CTZ, big.NewInt(0), 256, false, true},
+	}
+	for _, tt := range tests {
+		vm := NewVM()
+		vm.R[1].Set(tt.x)
+		vm.SetFlag(CF, !tt.cf)
+		if err := vm.Execute(tt.opcode<<24 | 0<<20 | 1<<16); err != nil {
+			t.Errorf("%s failed: %v", tt.name, err)
+			continue
+		}
+		if vm.R[0].Int64() != tt.want {
+			t.Errorf("%s failed: expected %d, got %v", tt.name, tt.want, vm.R[0])
+		}
+		if vm.GetFlag(ZF) != tt.zf || vm.GetFlag(CF) != tt.cf {
+			t.Errorf("%s failed: expected ZF=%v CF=%v, got SR = 0x%02X", tt.name, tt.zf, tt.cf, vm.SR)
+		}
+	}
+}
+
+// TestBswap tests that BSWAP reverses the 32 bytes of a register.
+func TestBswap(t *testing.T) {
+	vm := NewVM()
+	vm.R[1].SetInt64(0x0102)
+	vm.Bswap(0, 1)
+	want := new(big.Int).Lsh(big.NewInt(0x0201), 240)
+	if vm.R[0].Cmp(want) != 0 {
+		t.Errorf("BSWAP failed: expected %x, got %x", want, vm.R[0])
+	}
+	vm.Bswap(0, 0)
+	if vm.R[0].Int64() != 0x0102 {
+		t.Errorf("BSWAP failed: expected 0x102 after two swaps, got %x", vm.R[0])
+	}
+	vm.R[1].SetInt64(-1)
+	vm.Bswap(0, 1)
+	if vm.R[0].Cmp(mask256) != 0 || vm.GetFlag(ZF) {
+		t.Errorf("BSWAP failed: expected all ones, got %x", vm.R[0])
+	}
+}
+
+// TestBitTest tests BT, BTS, BTR and BTC with register and immediate bit
+// indexes.
+func TestBitTest(t *testing.T) {
+	top := new(big.Int).Lsh(big.NewInt(1), 255)
+	tests := []struct {
+		name        string
+		instruction uint32
+		x           *big.Int
+		index       int64
+		want        *big.Int
+		cf          bool
+	}{
+		{"BT", OP_BT<<24 | 1<<16 | 2<<12, big.NewInt(4), 2, nil, true},
+		{"BT clear", OP_BT<<24 | 1<<16 | 2<<12, big.NewInt(4), 1, nil, false},
+		{"BT wraps", OP_BT<<24 | 1<<16 | 2<<12, top, 255 + 256, nil, true},
+		{"BT negative", OP_BTI<<24 | 1<<16 | 255, big.NewInt(-1), 0, nil, true},
+		{"BTS", OP_BTS<<24 | 0<<20 | 1<<16 | 2<<12, big.NewInt(1), 255, new(big.Int).Add(top, big.NewInt(1)), false},
+		{"BTS set", OP_BTSI<<24 | 0<<20 | 1<<16 | 0, big.NewInt(1), 0, big.NewInt(1), true},
+		{"BTR", OP_BTR<<24 | 0<<20 | 1<<16 | 2<<12, big.NewInt(6), 1, big.NewInt(4), true},
+		{"BTR clear", OP_BTRI<<24 | 0<<20 | 1<<16 | 3, big.NewInt(6), 0, big.NewInt(6), false},
+		{"BTR zero", OP_BTRI<<24 | 0<<20 | 1<<16 | 255, top, 0, big.NewInt(0), true},
+		{"BTC", OP_BTC<<24 | 0<<20 | 1<<16 | 2<<12, big.NewInt(6), 0, big.NewInt(7), false},
+		{"BTC set", OP_BTCI<<24 | 0<<20 | 1<<16 | 2, big.NewInt(6), 0, big.NewInt(2), true},
+	}
+	for _, tt := range tests {
+		vm := NewVM()
+		vm.R[0].SetInt64(42)
+		vm.R[1].Set(tt.x)
+		vm.R[2].SetInt64(tt.index)
+		vm.SetFlag(CF, !tt.cf)
+		if err := vm.Execute(tt.instruction); err != nil {
+			t.Errorf("%s failed: %v", tt.name, err)
+			continue
+		}
+		if vm.GetFlag(CF) != tt.cf {
+			t.Errorf("%s failed: expected CF=%v", tt.name, tt.cf)
+		}
+		want := tt.want
+		if want == nil {
+			want = big.NewInt(42) // BT only reads its operand
+		}
+		if vm.R[0].Cmp(want) != 0 {
+			t.Errorf("%s failed: expected %v, got %v", tt.name, want, vm.R[0])
+		}
+		if tt.want != nil && vm.GetFlag(ZF) != (tt.want.Sign() == 0) {
+			t.Errorf("%s failed: unexpected ZF", tt.name)
+		}
+	}
+}